@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// accountNameCache keeps the explicitly configured accounts whose name was
+// resolved for the lifetime of the process, failed lookups are retried on the
+// next scrape.
+type accountNameCache struct {
+	mu       sync.RWMutex
+	accounts map[string]monitoredAccount
+}
+
+var accountNames = &accountNameCache{accounts: map[string]monitoredAccount{}}
+
+func (c *accountNameCache) get(id string) (monitoredAccount, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	a, ok := c.accounts[id]
+	return a, ok
+}
+
+func (c *accountNameCache) set(a monitoredAccount) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.accounts[a.ID] = a
+}
+
+func fetchAccountDetails(ctx context.Context, token apiToken, id string) (cloudflare.Account, error) {
+	ctx, span := tracer.Start(ctx, "fetchAccountDetails")
+	defer span.End()
+
+	api, err := newAPIClient(token)
+	if err != nil {
+		return cloudflare.Account{}, err
+	}
+
+	account, _, err := api.Account(ctx, id)
+	return account, err
+}
+
+// unknownAccountName renders -unknown_account_name for an account whose name
+// is not available, {id} is replaced by the account ID.
+func unknownAccountName(id string) string {
+	return strings.ReplaceAll(cfgUnknownAccountName, "{id}", id)
+}
+
+// explicitAccounts builds the accounts configured with -account_id without
+// listing the accounts of the tokens. Each ID is bound to the first token able
+// to look it up, and falls back to -unknown_account_name when none can.
+func explicitAccounts(ctx context.Context, ids []string) []monitoredAccount {
+	accounts := make([]monitoredAccount, 0, len(ids))
+	for _, id := range ids {
+		if a, ok := accountNames.get(id); ok {
+			accounts = append(accounts, a)
+			continue
+		}
+
+		resolved := false
+		for _, t := range apiTokens {
+			details, err := fetchAccountDetails(ctx, t, id)
+			if err != nil {
+				log.Debugf("Resolving name of account %s with token %s: %s", id, t.name, err)
+				continue
+			}
+
+			a := monitoredAccount{Account: details, token: t}
+			accountNames.set(a)
+			accounts = append(accounts, a)
+			resolved = true
+			break
+		}
+		if !resolved {
+			name := unknownAccountName(id)
+			log.Warnf("Could not resolve the name of account %s, using %s as label", id, name)
+			accounts = append(accounts, monitoredAccount{Account: cloudflare.Account{ID: id, Name: name}, token: apiTokens[0]})
+		}
+	}
+
+	return accounts
+}
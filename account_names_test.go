@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestExplicitAccountNames(t *testing.T) {
+	id := testAccount().ID
+	details := restFixture(http.MethodGet, "/accounts/"+id, "account_details.json")
+	details.token = "second-token"
+	denied := restFixture(http.MethodGet, "/accounts/"+id, "rest_error.json")
+	denied.status = http.StatusForbidden
+
+	tests := []struct {
+		name      string
+		fixtures  []mockFixture
+		wantName  string
+		wantToken string
+		wantCalls int
+	}{
+		{
+			name:      "resolved by the second token",
+			fixtures:  []mockFixture{details, denied},
+			wantName:  "Acme Streaming",
+			wantToken: "second",
+			// Both tokens on the first scrape, none afterwards.
+			wantCalls: 2,
+		},
+		{
+			name:      "unresolved",
+			fixtures:  []mockFixture{denied},
+			wantName:  "unknown-" + id,
+			wantToken: "first",
+			// Retried on every scrape.
+			wantCalls: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &apiTokens, []apiToken{{name: "first", value: "first-token"}, {name: "second", value: "second-token"}})
+			setConfig(t, &accountNames, &accountNameCache{accounts: map[string]monitoredAccount{}})
+			setConfig(t, &cfgAccountIDs, id)
+			setConfig(t, &cfgUnknownAccountName, "unknown-{id}")
+			resetMetrics(t)
+			m := newMockCloudflare(t, tt.fixtures...)
+
+			for scrape := 0; scrape < 2; scrape++ {
+				accounts, err := fetchAllAccounts(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(accounts) != 1 || accounts[0].Name != tt.wantName || accounts[0].token.name != tt.wantToken {
+					t.Fatalf("scrape %d: got %+v, want %s with token %s", scrape, accounts, tt.wantName, tt.wantToken)
+				}
+			}
+			if got := len(m.requests("/client/v4/accounts/" + id)); got != tt.wantCalls {
+				t.Errorf("got %d account lookups, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUnknownAccountName(t *testing.T) {
+	tests := []struct {
+		template, want string
+	}{
+		{template: "{id}", want: "abc"},
+		{template: "account-{id}", want: "account-abc"},
+		{template: "unknown", want: "unknown"},
+	}
+	for _, tt := range tests {
+		setConfig(t, &cfgUnknownAccountName, tt.template)
+		if got := unknownAccountName("abc"); got != tt.want {
+			t.Errorf("unknownAccountName() with %q = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	// Accounts listed without a name get the same label.
+	setConfig(t, &cfgUnknownAccountName, "account-{id}")
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	listed := restFixture(http.MethodGet, "/accounts", "")
+	listed.body = `{"success":true,"errors":[],"messages":[],"result":[{"id":"abc","name":""}],"result_info":{"page":1,"per_page":20,"total_pages":1,"count":1,"total_count":1}}`
+	newMockCloudflare(t, listed)
+	accounts, err := fetchAllAccounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (cloudflare.Account{ID: "abc", Name: "account-abc"}); len(accounts) != 1 || accounts[0].Account != want {
+		t.Errorf("got %+v, want %+v", accounts, want)
+	}
+}
+
+func TestUnknownAccountNameLabel(t *testing.T) {
+	tests := []struct {
+		name, listedName, template, want string
+	}{
+		{"resolved name", "Acme Streaming", "unknown-{id}", "Acme Streaming"},
+		{"templated fallback", "", "unknown-{id}", "unknown-" + testAccount().ID},
+		{"default fallback", "", "{id}", testAccount().ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgUnknownAccountName, tt.template)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			listed := restFixture(http.MethodGet, "/accounts", "")
+			listed.body = `{"success":true,"errors":[],"messages":[],"result":[{"id":"` + testAccount().ID + `","name":"` + tt.listedName + `"}],"result_info":{"page":1,"per_page":20,"total_pages":1,"count":1,"total_count":1}}`
+			newMockCloudflare(t, listed, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+			fetchMetrics(context.Background())
+
+			labels := map[string]string{"account": tt.want, "account_id": testAccount().ID}
+			if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", labels); !ok || got != 80 {
+				t.Errorf("got minutes viewed %v (exported %t) with labels %v, want 80", got, ok, labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// accountAliases maps account IDs to the name used for the account label,
+// loaded from -account_alias_file.
+var accountAliases map[string]string
+
+func loadAccountAliases(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := map[string]string{}
+	if err := yaml.Unmarshal(raw, &aliases); err != nil {
+		return nil, fmt.Errorf("parsing -account_alias_file %s: %w", path, err)
+	}
+	return aliases, nil
+}
+
+// labelTemplate renders the account label from -label_template, nil to use
+// the cloudflare name.
+var labelTemplate *template.Template
+
+// labelTemplateData are the account fields available to -label_template.
+type labelTemplateData struct {
+	ID, Name, Type string
+}
+
+// parseLabelTemplate parses -label_template and renders it once against a
+// sample account, so references to unknown fields fail at startup.
+func parseLabelTemplate(raw string) (*template.Template, error) {
+	tmpl, err := template.New("label_template").Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing -label_template: %w", err)
+	}
+	if err := tmpl.Execute(&strings.Builder{}, labelTemplateData{ID: "id", Name: "name", Type: "standard"}); err != nil {
+		return nil, fmt.Errorf("invalid -label_template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// templateFailures remembers the accounts -label_template failed for, so
+// the failure is logged once instead of on every label lookup.
+var templateFailures sync.Map
+
+func renderLabelTemplate(a monitoredAccount) (string, bool) {
+	var b strings.Builder
+	if err := labelTemplate.Execute(&b, labelTemplateData{ID: a.ID, Name: a.Name, Type: a.Type}); err != nil {
+		if _, seen := templateFailures.LoadOrStore(a.ID, true); !seen {
+			log.Warnf("Rendering -label_template for %s, using its name: %s", a.ID, err)
+		}
+		return "", false
+	}
+	return b.String(), b.Len() > 0
+}
+
+// accountDisplayName returns the alias of the account, else the rendered
+// -label_template, else its cloudflare name. Names shared with another
+// account get a short ID suffix when the account label is the only one.
+func accountDisplayName(a monitoredAccount) string {
+	name := baseDisplayName(a)
+	if cfgLabelBy == "name" && duplicateNames.contains(a.ID) {
+		return name + "-" + shortAccountID(a.ID)
+	}
+	return name
+}
+
+func baseDisplayName(a monitoredAccount) string {
+	if alias, ok := accountAliases[a.ID]; ok && len(alias) > 0 {
+		return alias
+	}
+	if labelTemplate != nil {
+		if name, ok := renderLabelTemplate(a); ok {
+			return name
+		}
+	}
+	return a.Name
+}
+
+func shortAccountID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// duplicateNameSet holds the IDs of the accounts sharing their display name
+// with another monitored account. An account stays in the set once a
+// collision was seen, so its label does not flip back and forth as the other
+// account comes and goes. names holds the last display name of each account.
+type duplicateNameSet struct {
+	mu    sync.RWMutex
+	ids   map[string]bool
+	names map[string]string
+}
+
+var duplicateNames = &duplicateNameSet{}
+
+func (s *duplicateNameSet) contains(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ids[id]
+}
+
+// markDuplicateNames finds the accounts whose display name collides. Only
+// with -label_by=name would their series overwrite each other, so that is
+// when the collision is logged and the names suffixed.
+func markDuplicateNames(accounts []monitoredAccount) {
+	byName := map[string][]string{}
+	for _, a := range accounts {
+		name := baseDisplayName(a)
+		if !contains(byName[name], a.ID) {
+			byName[name] = append(byName[name], a.ID)
+		}
+	}
+
+	duplicateNames.mu.Lock()
+	if duplicateNames.ids == nil {
+		duplicateNames.ids = map[string]bool{}
+	}
+	for name, accountIDs := range byName {
+		if len(accountIDs) < 2 {
+			continue
+		}
+		var added bool
+		for _, id := range accountIDs {
+			added = added || !duplicateNames.ids[id]
+			duplicateNames.ids[id] = true
+		}
+		if added && cfgLabelBy == "name" {
+			log.Warnf("Accounts %s share the name %s, suffixing their account label with a short ID", strings.Join(accountIDs, ", "), name)
+		}
+	}
+	duplicateNames.mu.Unlock()
+
+	forgetRenamedAccounts(accounts)
+}
+
+// forgetRenamedAccounts deletes the series an account left under its previous
+// display name, after a rename in cloudflare, an alias change or a collision
+// suffix, so only the series under the current name remain.
+func forgetRenamedAccounts(accounts []monitoredAccount) {
+	for _, a := range accounts {
+		name := accountDisplayName(a)
+
+		duplicateNames.mu.Lock()
+		if duplicateNames.names == nil {
+			duplicateNames.names = map[string]string{}
+		}
+		previous, seen := duplicateNames.names[a.ID]
+		duplicateNames.names[a.ID] = name
+		duplicateNames.mu.Unlock()
+
+		if seen && previous != name {
+			log.Infof("Account %s is now labelled %s, deleting its series labelled %s", a.ID, name, previous)
+			tenants.deletePartialMatch(a.ID, prometheus.Labels{"account": previous})
+		}
+	}
+}
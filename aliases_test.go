@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestAccountAliasFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aliases.yaml")
+	if err := os.WriteFile(path, []byte("# friendly names\n"+testAccount().ID+": Streaming (prod)\n7c5dae5552338874e5053f2534d2767a: \"\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadAccountAliases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, &accountAliases, aliases)
+
+	tests := []struct {
+		name    string
+		account monitoredAccount
+		want    string
+	}{
+		{"aliased", testAccount(), "Streaming (prod)"},
+		{"empty alias", monitoredAccount{Account: cloudflare.Account{ID: "7c5dae5552338874e5053f2534d2767a", Name: "Acme Staging"}}, "Acme Staging"},
+		{"no alias", monitoredAccount{Account: cloudflare.Account{ID: "c", Name: "Other Corp"}}, "Other Corp"},
+	}
+	for _, tt := range tests {
+		if got := accountDisplayName(tt.account); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	resetMetrics(t)
+	setMinutesViewed(testAccount(), 80)
+	labels := map[string]string{"account": "Streaming (prod)", "account_id": testAccount().ID}
+	if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", labels); !ok || got != 80 {
+		t.Errorf("got minutes viewed %v (exported %t) with labels %v, want 80", got, ok, labels)
+	}
+}
+
+func TestAccountAliasFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("- not\n- a mapping\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.yaml")} {
+		if _, err := loadAccountAliases(path); err == nil {
+			t.Errorf("loadAccountAliases(%s) accepted it", filepath.Base(path))
+		}
+	}
+}
+
+func TestParseLabelTemplate(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: "{{.Name}}-{{.Type}}"},
+		{raw: "{{.ID}}"},
+		{raw: "static"},
+		{raw: "{{.Name", wantErr: true},
+		{raw: "{{.Zone}}", wantErr: true},
+		{raw: "{{template \"other\"}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := parseLabelTemplate(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLabelTemplate(%q) error = %v, want error %t", tt.raw, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLabelTemplate(t *testing.T) {
+	acme := testAccount()
+	acme.Type = "standard"
+	enterprise := monitoredAccount{Account: cloudflare.Account{ID: "7c5dae5552338874e5053f2534d2767a", Name: "Acme Staging", Type: "enterprise"}}
+	tests := []struct {
+		name     string
+		template string
+		aliases  map[string]string
+		account  monitoredAccount
+		want     string
+	}{
+		{"name and type", "{{.Name}}-{{.Type}}", nil, acme, "Acme Streaming-standard"},
+		{"id", "acct-{{.ID}}", nil, acme, "acct-" + acme.ID},
+		{"alias wins", "{{.Name}}-{{.Type}}", map[string]string{acme.ID: "Streaming (prod)"}, acme, "Streaming (prod)"},
+		{"empty render", "{{if eq .Type \"enterprise\"}}{{.Name}}{{end}}", nil, acme, "Acme Streaming"},
+		// Parses against the sample account but fails for this one.
+		{"execution error", "{{if eq .Type \"standard\"}}{{.Name}}{{else}}{{index .Name 99}}{{end}}", nil, enterprise, "Acme Staging"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseLabelTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setConfig(t, &labelTemplate, tmpl)
+			setConfig(t, &accountAliases, tt.aliases)
+
+			if got := accountDisplayName(tt.account); got != tt.want {
+				t.Errorf("got account label %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateAccountNames(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	accounts := `{"success": true, "errors": [], "messages": [], "result": [
+		{"id": "023e105f4ecef8ad9ca31a8372d0c353", "name": "Acme Streaming", "type": "standard"},
+		{"id": "7c5dae5552338874e5053f2534d2767a", "name": "Acme Streaming", "type": "standard"},
+		{"id": "9a7806061c88ada191ed06f989cc3dac", "name": "Other Corp", "type": "standard"}
+	], "result_info": {"page": 1, "per_page": 50, "total_pages": 1, "count": 3, "total_count": 3}}`
+	tests := []struct {
+		labelBy string
+		want    []prometheus.Labels
+		warned  bool
+	}{
+		{"name", []prometheus.Labels{
+			{"account": "Acme Streaming-023e105f"},
+			{"account": "Acme Streaming-7c5dae55"},
+			{"account": "Other Corp"},
+		}, true},
+		// The account_id label tells them apart already.
+		{"both", []prometheus.Labels{
+			{"account": "Acme Streaming", "account_id": testAccount().ID},
+			{"account": "Acme Streaming", "account_id": staging},
+			{"account": "Other Corp", "account_id": "9a7806061c88ada191ed06f989cc3dac"},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.labelBy, func(t *testing.T) {
+			setConfig(t, &cfgLabelBy, tt.labelBy)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			setConfig(t, &duplicateNames, &duplicateNameSet{})
+			resetMetrics(t)
+			newMockCloudflare(t,
+				mockFixture{method: http.MethodGet, path: "/client/v4/accounts", body: accounts},
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+			)
+			hook := test.NewGlobal()
+			t.Cleanup(func() { log.StandardLogger().ReplaceHooks(log.LevelHooks{}) })
+
+			fetchMetrics(context.Background())
+
+			if got := countSeries(t, tenants.all(), "cloudflare_streaming_minutes_viewed"); got != len(tt.want) {
+				t.Errorf("got %d minutes viewed series, want %d", got, len(tt.want))
+			}
+			for _, labels := range tt.want {
+				if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", labels); !ok || got != 80 {
+					t.Errorf("got %v (exported %t) for %v, want 80", got, ok, labels)
+				}
+			}
+			warned := false
+			for _, e := range hook.AllEntries() {
+				if e.Level == log.WarnLevel && strings.Contains(e.Message, "share the name Acme Streaming") {
+					warned = true
+				}
+			}
+			if warned != tt.warned {
+				t.Errorf("got collision warned %t, want %t", warned, tt.warned)
+			}
+		})
+	}
+}
+
+func TestDuplicateNameSuffixSticky(t *testing.T) {
+	acme := `{"id": "023e105f4ecef8ad9ca31a8372d0c353", "name": "Acme Streaming", "type": "standard"}`
+	staging := `{"id": "7c5dae5552338874e5053f2534d2767a", "name": "Acme Streaming", "type": "standard"}`
+	accounts := func(list ...string) mockFixture {
+		return mockFixture{method: http.MethodGet, path: "/client/v4/accounts", body: `{"success": true, "errors": [], "messages": [], "result": [` +
+			strings.Join(list, ",") + fmt.Sprintf(`], "result_info": {"page": 1, "per_page": 50, "total_pages": 1, "count": %d, "total_count": %d}}`, len(list), len(list))}
+	}
+	setConfig(t, &cfgLabelBy, "name")
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &duplicateNames, &duplicateNameSet{})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		accounts(acme),
+		accounts(acme, staging),
+		accounts(acme),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	cycles := []struct {
+		name       string
+		want, gone string
+	}{
+		{"alone", "Acme Streaming", "Acme Streaming-023e105f"},
+		// The unsuffixed series is deleted rather than left stale.
+		{"collision", "Acme Streaming-023e105f", "Acme Streaming"},
+		// The suffix stays once the other account is gone.
+		{"other account gone", "Acme Streaming-023e105f", "Acme Streaming"},
+	}
+	for _, cycle := range cycles {
+		fetchMetrics(context.Background())
+
+		g := tenants.account(testAccount().ID)
+		if got, ok := gatheredValue(t, g, "cloudflare_streaming_minutes_viewed", prometheus.Labels{"account": cycle.want}); !ok || got != 80 {
+			t.Errorf("%s: got %v (exported %t) for %s, want 80", cycle.name, got, ok, cycle.want)
+		}
+		if _, ok := gatheredValue(t, g, "cloudflare_streaming_minutes_viewed", prometheus.Labels{"account": cycle.gone}); ok {
+			t.Errorf("%s: series labelled %s is still exported", cycle.name, cycle.gone)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Registered by registerAccountMetrics when -require_all_accounts_have_data
+// is set.
+var cfAccountWithoutData *accountGaugeVec
+
+func registerAuditMetric() {
+	cfAccountWithoutData = newAccountGaugeVec("", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_account_without_data",
+		Help: "Whether the monitored account returned no stream data on the first full scrape",
+	}, accountLabelNames(),
+	)
+}
+
+// dataAudit records which accounts returned rows until the first full scrape
+// has been audited.
+type dataAudit struct {
+	mu       sync.Mutex
+	done     bool
+	withData map[string]bool
+}
+
+var firstScrapeAudit = &dataAudit{withData: map[string]bool{}}
+
+func (d *dataAudit) markData(account monitoredAccount) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.done {
+		d.withData[account.ID] = true
+	}
+}
+
+// run flags the monitored accounts that had no data, once. It waits for a
+// scrape that enumerated accounts, so a failing first scrape is not audited.
+func (d *dataAudit) run(accounts []monitoredAccount) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.done || len(accounts) == 0 {
+		return
+	}
+	d.done = true
+
+	missing := 0
+	for _, a := range accounts {
+		if d.withData[a.ID] {
+			cfAccountWithoutData.set(a, accountLabels(a), 0)
+			continue
+		}
+		missing++
+		log.Errorf("Account %s (%s) returned no stream data, it may be included by mistake", a.Name, a.ID)
+		cfAccountWithoutData.set(a, accountLabels(a), 1)
+	}
+	log.Infof("Audited %d accounts after the first scrape, %d without stream data", len(accounts), missing)
+	d.withData = nil
+}
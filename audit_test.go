@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestRequireAllAccountsHaveData(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	setConfig(t, &cfgRequireAllAccountsHaveData, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &firstScrapeAudit, &dataAudit{withData: map[string]bool{}})
+	resetMetrics(t)
+	acme := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+	acme.account = testAccount().ID
+	empty := graphqlFixture("StreamMinutesViewed", "")
+	empty.account, empty.body = staging, `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": []}]}}}`
+	m := newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"), acme, empty)
+	hook := test.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(log.LevelHooks{}) })
+
+	tryScrapeAndPush(context.Background())
+
+	accounts := lastAccounts.all()
+	if len(accounts) != 2 {
+		t.Fatalf("got %d accounts, want the two of testdata/accounts.json", len(accounts))
+	}
+	want := map[string]float64{testAccount().ID: 0, staging: 1}
+	for _, a := range accounts {
+		got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_account_without_data", accountLabels(a))
+		if !ok || got != want[a.ID] {
+			t.Errorf("got account without data %v (exported %t) for %s, want %v", got, ok, a.Name, want[a.ID])
+		}
+	}
+	auditErrors := func() []string {
+		var messages []string
+		for _, e := range hook.AllEntries() {
+			if e.Level == log.ErrorLevel && strings.Contains(e.Message, "returned no stream data") {
+				messages = append(messages, e.Message)
+			}
+		}
+		return messages
+	}
+	if got := auditErrors(); len(got) != 1 || !strings.Contains(got[0], staging) {
+		t.Errorf("got audit errors %q, want one for %s", got, staging)
+	}
+
+	// The audit is one-time: acme losing its data later is not reported.
+	acmeEmpty := empty
+	acmeEmpty.account = testAccount().ID
+	m.mu.Lock()
+	m.fixtures = []mockFixture{m.fixtures[0], empty, acmeEmpty}
+	m.mu.Unlock()
+	tryScrapeAndPush(context.Background())
+
+	if got := auditErrors(); len(got) != 1 {
+		t.Errorf("got audit errors %q after a second scrape, want only the first one", got)
+	}
+	if got, _ := gatheredValue(t, tenants.all(), "cloudflare_stream_account_without_data", accountLabels(testAccount())); got != 0 {
+		t.Errorf("got account without data %v for %s after a second scrape, want 0", got, testAccount().Name)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/machinebox/graphql"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBatchAccounts bounds how many accounts share one graphql query, the
+// row limit of the dataset applies per account so this mostly bounds the
+// response size.
+const maxBatchAccounts = 25
+
+func fetchStreamingTotalsBatch(ctx context.Context, accounts []monitoredAccount, start, end time.Time) (*cfResponseStreamingAnalytics, error) {
+	ctx, span := tracer.Start(ctx, "fetchStreamingTotalsBatch", trace.WithAttributes(attribute.Int("accounts", len(accounts))))
+	defer span.End()
+
+	ids := make([]string, 0, len(accounts))
+	for _, a := range accounts {
+		ids = append(ids, a.ID)
+	}
+
+	request := graphql.NewRequest(buildStreamingQuery(true))
+	request.Header.Set("Authorization", "Bearer "+accounts[0].token.value)
+	request.Var("maxtime", end)
+	request.Var("mintime", start)
+	request.Var("accountIDs", ids)
+
+	graphqlClient := graphql.NewClient(cfGraphQLEndpoint, graphql.WithHTTPClient(apiClient))
+	var resp cfResponseStreamingAnalytics
+	if err := graphqlClient.Run(ctx, request, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// batchAccounts groups the accounts by token, since a query authenticates
+// with a single token, in batches of at most maxBatchAccounts.
+func batchAccounts(accounts []monitoredAccount) [][]monitoredAccount {
+	byToken := map[string][]monitoredAccount{}
+	var order []string
+	for _, a := range accounts {
+		if _, ok := byToken[a.token.name]; !ok {
+			order = append(order, a.token.name)
+		}
+		byToken[a.token.name] = append(byToken[a.token.name], a)
+	}
+
+	var batches [][]monitoredAccount
+	for _, name := range order {
+		tokenAccounts := byToken[name]
+		for len(tokenAccounts) > maxBatchAccounts {
+			batches = append(batches, tokenAccounts[:maxBatchAccounts])
+			tokenAccounts = tokenAccounts[maxBatchAccounts:]
+		}
+		batches = append(batches, tokenAccounts)
+	}
+
+	return batches
+}
+
+// fetchStreamingAnalyticsBatched runs the batches on -concurrency workers,
+// then the per account datasets on the same number of workers. The datasets
+// wait for every batch since the period comparison reads the window totals
+// the batches record.
+func fetchStreamingAnalyticsBatched(ctx context.Context, accounts []monitoredAccount) {
+	start, end := scrapeWindow()
+
+	pool := newWorkerPool(cfgConcurrency)
+	for _, batch := range batchAccounts(accounts) {
+		batch := batch
+		pool.run(func() {
+			if ctx.Err() != nil {
+				for _, a := range batch {
+					skipPastDeadline(a)
+				}
+				return
+			}
+			batchCtx, cancel := context.WithTimeout(ctx, cfgRequestTimeout)
+			defer cancel()
+
+			fetchMinutesViewedBatch(batchCtx, batch, start, end)
+		})
+	}
+	pool.wait()
+
+	for _, a := range accounts {
+		a := a
+		pool.run(func() {
+			if ctx.Err() != nil {
+				return
+			}
+			accountCtx, cancel := context.WithTimeout(ctx, cfgRequestTimeout)
+			defer cancel()
+
+			fetchAccountDatasets(accountCtx, a, start, end)
+		})
+	}
+	pool.wait()
+}
+
+func fetchMinutesViewedBatch(ctx context.Context, batch []monitoredAccount, start, end time.Time) {
+	log.Debugf("Fetching streaming analytics for %d accounts", len(batch))
+	r, err := fetchStreamingTotalsBatch(ctx, batch, start, end)
+	for _, a := range batch {
+		recordFetchResult(a, err)
+	}
+	if err != nil {
+		log.Error(err)
+		if cfgEnableRESTFallback {
+			for _, a := range batch {
+				fetchStreamingAnalyticsREST(ctx, a, start, end)
+			}
+		}
+		return
+	}
+
+	byID := map[string]cfResponseStreamingAnalyticsResp{}
+	for _, a := range r.Viewer.Accounts {
+		byID[a.AccountTag] = a
+	}
+	for _, a := range batch {
+		if cfgEnableRESTFallback {
+			cfStreamUsingFallback.set(a, accountLabels(a), 0)
+		}
+		// Accounts without any views may be left out of the response.
+		processStreamingAnalytics(a, byID[a.ID], start, end)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestBatchAccounts(t *testing.T) {
+	setConfig(t, &cfgBatchAccounts, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewedBatch", "streaming_analytics_batch.json"),
+	)
+
+	fetchMetrics(context.Background())
+
+	requests := m.requests("/graphql/")
+	var batches []mockRequest
+	for _, r := range requests {
+		if strings.Contains(r.query, "accountTag_in: $accountIDs") {
+			batches = append(batches, r)
+		}
+	}
+	if len(batches) != 1 {
+		t.Fatalf("got %d batched queries, want 1", len(batches))
+	}
+	if got := fmt.Sprint(batches[0].variables["accountIDs"]); got != "[023e105f4ecef8ad9ca31a8372d0c353 7c5dae5552338874e5053f2534d2767a]" {
+		t.Errorf("queried accounts %s, want both accounts of testdata/accounts.json", got)
+	}
+
+	// The response lists the accounts in another order than queried.
+	for _, tt := range []struct {
+		account monitoredAccount
+		want    float64
+	}{
+		{testAccount(), 80},
+		{monitoredAccount{Account: cloudflare.Account{ID: "7c5dae5552338874e5053f2534d2767a", Name: "Acme Staging"}}, 20},
+	} {
+		got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(tt.account))
+		if !ok || got != tt.want {
+			t.Errorf("got minutes viewed %v (exported %t) for %s, want %v", got, ok, tt.account.Name, tt.want)
+		}
+	}
+}
+
+func TestBatchAccountsSplit(t *testing.T) {
+	var accounts []monitoredAccount
+	for i := 0; i < maxBatchAccounts+1; i++ {
+		accounts = append(accounts, monitoredAccount{Account: cloudflare.Account{ID: fmt.Sprint("a", i)}, token: apiToken{name: "token0"}})
+	}
+	accounts = append(accounts, monitoredAccount{Account: cloudflare.Account{ID: "b"}, token: apiToken{name: "token1"}})
+
+	var sizes []int
+	for _, batch := range batchAccounts(accounts) {
+		sizes = append(sizes, len(batch))
+	}
+	if got, want := fmt.Sprint(sizes), fmt.Sprint([]int{maxBatchAccounts, 1, 1}); got != want {
+		t.Errorf("got batches of %s accounts, want %s", got, want)
+	}
+}
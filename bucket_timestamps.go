@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type bucketSample struct {
+	labelValues []string
+	value       float64
+	ts          time.Time
+}
+
+// bucketCollector exposes the viewed metric stamped with the time of the
+// cloudflare bucket it came from instead of the scrape time. Prometheus only
+// accepts one sample per series per scrape, so each account keeps the most
+// recent complete bucket; earlier buckets have been exposed by earlier scrapes.
+type bucketCollector struct {
+	mu         sync.Mutex
+	desc       *prometheus.Desc
+	labelNames []string
+	samples    map[string]bucketSample
+}
+
+func newBucketCollector(name, help string, labelNames []string) *bucketCollector {
+	return &bucketCollector{
+		desc:       prometheus.NewDesc(name, help, labelNames, nil),
+		labelNames: labelNames,
+		samples:    map[string]bucketSample{},
+	}
+}
+
+func (c *bucketCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *bucketCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range c.samples {
+		m := prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, s.value, s.labelValues...)
+		if !s.ts.IsZero() {
+			m = prometheus.NewMetricWithTimestamp(s.ts, m)
+		}
+		ch <- m
+	}
+}
+
+// set records value for the label set, a zero ts exposes it at scrape time.
+func (c *bucketCollector) set(labels prometheus.Labels, value float64, ts time.Time) {
+	values := make([]string, 0, len(c.labelNames))
+	for _, n := range c.labelNames {
+		values = append(values, labels[n])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples[strings.Join(values, "\xff")] = bucketSample{labelValues: values, value: value, ts: ts}
+}
+
+// deletePartialMatch drops the samples matching all the given labels.
+func (c *bucketCollector) deletePartialMatch(labels prometheus.Labels) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, s := range c.samples {
+		matches := true
+		for i, n := range c.labelNames {
+			if v, ok := labels[n]; ok && s.labelValues[i] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			delete(c.samples, key)
+		}
+	}
+}
+
+// latestCompleteBucket returns the time and summed minutes of the newest
+// bucket that ended before end, since the bucket still being filled would
+// later be exposed again with a different value at the same timestamp.
+func latestCompleteBucket(rows []cfStreamMinutesViewedGroup, end time.Time) (time.Time, uint64, bool) {
+	var latest time.Time
+	for _, r := range rows {
+		if r.Dimensions.Ts.Add(cfgGranularity).After(end) {
+			continue
+		}
+		if r.Dimensions.Ts.After(latest) {
+			latest = r.Dimensions.Ts
+		}
+	}
+	if latest.IsZero() {
+		return latest, 0, false
+	}
+
+	var minutes uint64
+	for _, r := range rows {
+		if r.Dimensions.Ts.Equal(latest) {
+			minutes += r.minutes()
+		}
+	}
+
+	return latest, minutes, true
+}
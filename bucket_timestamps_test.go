@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatestCompleteBucket(t *testing.T) {
+	base := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	row := func(offset time.Duration, minutes uint64) cfStreamMinutesViewedGroup {
+		var g cfStreamMinutesViewedGroup
+		v := jsonUint64(minutes)
+		g.Sum.MinutesViewed = &v
+		g.Dimensions.Ts = base.Add(offset)
+		return g
+	}
+	tests := []struct {
+		name        string
+		rows        []cfStreamMinutesViewedGroup
+		end         time.Time
+		wantTs      time.Time
+		wantMinutes uint64
+		wantOK      bool
+	}{
+		{
+			name:        "newest complete",
+			rows:        []cfStreamMinutesViewedGroup{row(0, 10), row(5*time.Minute, 20)},
+			end:         base.Add(10 * time.Minute),
+			wantTs:      base.Add(5 * time.Minute),
+			wantMinutes: 20,
+			wantOK:      true,
+		},
+		{
+			name:        "skips the bucket being filled",
+			rows:        []cfStreamMinutesViewedGroup{row(0, 10), row(5*time.Minute, 20)},
+			end:         base.Add(9 * time.Minute),
+			wantTs:      base,
+			wantMinutes: 10,
+			wantOK:      true,
+		},
+		{
+			name:        "sums the colos of a bucket",
+			rows:        []cfStreamMinutesViewedGroup{row(0, 10), row(0, 15)},
+			end:         base.Add(5 * time.Minute),
+			wantTs:      base,
+			wantMinutes: 25,
+			wantOK:      true,
+		},
+		{
+			name: "no complete bucket",
+			rows: []cfStreamMinutesViewedGroup{row(0, 10)},
+			end:  base.Add(time.Minute),
+		},
+	}
+	for _, tt := range tests {
+		ts, minutes, ok := latestCompleteBucket(tt.rows, tt.end)
+		if ok != tt.wantOK || !ts.Equal(tt.wantTs) || minutes != tt.wantMinutes {
+			t.Errorf("%s: latestCompleteBucket() = %s, %d, %t, want %s, %d, %t", tt.name, ts, minutes, ok, tt.wantTs, tt.wantMinutes, tt.wantOK)
+		}
+	}
+}
+
+func TestBucketTimestamps(t *testing.T) {
+	setConfig(t, &cfgUseBucketTimestamps, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	families, err := tenants.all().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "cloudflare_streaming_minutes_viewed" {
+			continue
+		}
+		if len(family.GetMetric()) != 1 {
+			t.Fatalf("got %d series, want 1", len(family.GetMetric()))
+		}
+		m := family.GetMetric()[0]
+		// The newest bucket of the fixture.
+		want := time.Date(2022, 9, 1, 10, 10, 0, 0, time.UTC)
+		if got := time.UnixMilli(m.GetTimestampMs()).UTC(); !got.Equal(want) {
+			t.Errorf("got timestamp %s, want the bucket ts %s", got, want)
+		}
+		if got := m.GetGauge().GetValue(); got != 30 {
+			t.Errorf("got %v minutes viewed, want the 30 of the bucket", got)
+		}
+		return
+	}
+	t.Error("cloudflare_streaming_minutes_viewed not exported")
+}
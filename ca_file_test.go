@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCAFile(t *testing.T) {
+	body := readTestdata(t, "streaming_analytics.json")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	// The handshakes failing without the ca are expected.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		caFile     string
+		wantErr    bool
+		wantMinute bool
+	}{
+		{"system pool only", "", false, false},
+		{"custom ca", caFile, false, true},
+		{"no certificates", notPEM, true, false},
+		{"missing file", filepath.Join(dir, "missing.pem"), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := apiClient.Transport.(*apiTransport)
+			setConfig(t, &transport.next, transport.next)
+			setConfig(t, &cfgMaxRetries, 0)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			setConfig(t, &cfGraphQLEndpoint, server.URL+"/graphql/")
+			endpoints, err := parseGraphQLEndpoints(cfGraphQLEndpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setConfig(t, &graphqlEndpoints, endpoints)
+
+			if len(tt.caFile) > 0 {
+				err := useCAFile(tt.caFile)
+				if gotErr := err != nil; gotErr != tt.wantErr {
+					t.Fatalf("useCAFile() error = %v, want error %t", err, tt.wantErr)
+				}
+			}
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(testAccount()))
+			if ok != tt.wantMinute || (ok && got != 80) {
+				t.Errorf("got minutes viewed %v (exported %t), want exported %t", got, ok, tt.wantMinute)
+			}
+		})
+	}
+}
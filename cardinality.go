@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	cfSeriesLimitExceeded = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_series_limit_exceeded",
+		Help: "Whether the last scrape dropped new series because -max_series was reached",
+	})
+)
+
+// seriesLimiter caps the number of distinct label sets the exporter emits so
+// high cardinality groupings cannot exhaust prometheus memory. It counts the
+// per-account series written in the current scrape, every write goes through
+// accountGaugeVec or accountBucketCollector.
+type seriesLimiter struct {
+	mu       sync.Mutex
+	max      int
+	seen     map[string]struct{}
+	exceeded bool
+}
+
+var seriesLimit = &seriesLimiter{seen: map[string]struct{}{}}
+
+func seriesKey(metric string, labels prometheus.Labels) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(metric)
+	for _, k := range keys {
+		b.WriteString("\xff")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(labels[k])
+	}
+
+	return b.String()
+}
+
+// beginScrape starts counting from zero, so series deleted or no longer
+// written stop holding a place under the limit, and clears the exceeded
+// state so the gauge reflects the current scrape.
+func (l *seriesLimiter) beginScrape() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seen = map[string]struct{}{}
+	l.exceeded = false
+	cfSeriesLimitExceeded.Set(0)
+}
+
+// allow reports whether the series may be emitted. Series already written in
+// this scrape are always allowed, others only while below the limit.
+func (l *seriesLimiter) allow(metric string, labels prometheus.Labels) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := seriesKey(metric, labels)
+	if _, ok := l.seen[key]; ok {
+		return true
+	}
+
+	if l.max > 0 && len(l.seen) >= l.max {
+		if !l.exceeded {
+			log.Warnf("Series limit of %d reached, dropping new series starting with %s%v", l.max, metric, labels)
+		}
+		l.exceeded = true
+		cfSeriesLimitExceeded.Set(1)
+		return false
+	}
+
+	l.seen[key] = struct{}{}
+	return true
+}
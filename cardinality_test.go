@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesLimit(t *testing.T) {
+	tests := []struct {
+		max, accounts int
+		wantSeries    int
+		wantExceeded  float64
+	}{
+		{max: 0, accounts: 5, wantSeries: 5},
+		{max: 5, accounts: 5, wantSeries: 5},
+		{max: 2, accounts: 5, wantSeries: 2, wantExceeded: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("max %d", tt.max), func(t *testing.T) {
+			resetMetrics(t)
+			seriesLimit.max = tt.max
+			seriesLimit.beginScrape()
+
+			for i := 0; i < tt.accounts; i++ {
+				account := monitoredAccount{Account: cloudflare.Account{ID: fmt.Sprint("id", i), Name: fmt.Sprint("account", i)}}
+				setMinutesViewed(account, 10)
+				// Writing the same series again does not count twice.
+				setMinutesViewed(account, 20)
+			}
+
+			if got := countSeries(t, tenants.all(), "cloudflare_streaming_minutes_viewed"); got != tt.wantSeries {
+				t.Errorf("got %d series, want %d", got, tt.wantSeries)
+			}
+			if got := testutil.ToFloat64(cfSeriesLimitExceeded); got != tt.wantExceeded {
+				t.Errorf("got cloudflare_stream_series_limit_exceeded %v, want %v", got, tt.wantExceeded)
+			}
+		})
+	}
+}
+
+func TestSeriesLimitResetsEveryScrape(t *testing.T) {
+	resetMetrics(t)
+	seriesLimit.max = 1
+	first := monitoredAccount{Account: cloudflare.Account{ID: "id0", Name: "first"}}
+	second := monitoredAccount{Account: cloudflare.Account{ID: "id1", Name: "second"}}
+
+	seriesLimit.beginScrape()
+	setMinutesViewed(first, 10)
+	setMinutesViewed(second, 10)
+	if got := testutil.ToFloat64(cfSeriesLimitExceeded); got != 1 {
+		t.Fatalf("got cloudflare_stream_series_limit_exceeded %v, want 1", got)
+	}
+
+	// Once first is gone, second fits under the limit.
+	seriesLimit.beginScrape()
+	deleteMinutesViewed(first)
+	setMinutesViewed(second, 10)
+	if got := testutil.ToFloat64(cfSeriesLimitExceeded); got != 0 {
+		t.Errorf("got cloudflare_stream_series_limit_exceeded %v, want 0", got)
+	}
+	if _, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(second)); !ok {
+		t.Error("second account not exported after the first one left")
+	}
+}
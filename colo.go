@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherColo collects the colos beyond -max_colos so the colo label stays
+// bounded while the per-account total is preserved.
+const otherColo = "other"
+
+// Registered by registerAccountMetrics when -group_by_colo is set.
+var cfDistinctColos *accountGaugeVec
+
+func registerDistinctColosMetric() {
+	cfDistinctColos = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_distinct_colos",
+		Help: "Distinct colos with views in the query window, before -max_colos folds the tail into other",
+	}, accountLabelNames(),
+	)
+}
+
+// exportedColos remembers the colos each account was last exported with, by
+// accountKey, so a colo that stops serving or falls into other loses its
+// series instead of keeping its last value.
+var exportedColos = struct {
+	sync.Mutex
+	colos map[string]map[string]bool
+}{colos: map[string]map[string]bool{}}
+
+// deleteStaleColos deletes the viewed series of the colos the account was
+// exported with before but not in groups, then remembers groups.
+func deleteStaleColos(account monitoredAccount, groups map[string][]cfStreamMinutesViewedGroup) {
+	current := map[string]bool{}
+	for colo := range groups {
+		current[colo] = true
+	}
+
+	exportedColos.Lock()
+	defer exportedColos.Unlock()
+
+	key := accountKey(account)
+	for colo := range exportedColos.colos[key] {
+		if current[colo] {
+			continue
+		}
+		labels := viewedLabels(account, colo)
+		if cfBucketMinutesViewed != nil {
+			cfBucketMinutesViewed.deletePartialMatch(account, labels)
+		} else {
+			cfStreamingMinutesViewed.deletePartialMatch(account, labels)
+		}
+	}
+	exportedColos.colos[key] = current
+}
+
+func distinctColos(rows []cfStreamMinutesViewedGroup) int {
+	colos := map[string]struct{}{}
+	for _, r := range rows {
+		colos[r.Dimensions.Colo] = struct{}{}
+	}
+	return len(colos)
+}
+
+// viewedLabelNames returns the labels of the viewed metric, which carries a
+// colo label on top of the account labels when -group_by_colo is set.
+func viewedLabelNames() []string {
+	names := accountLabelNames()
+	if cfgGroupByColo {
+		names = append(names, "colo")
+	}
+	return names
+}
+
+func viewedLabels(account monitoredAccount, colo string) prometheus.Labels {
+	labels := accountLabels(account)
+	if cfgGroupByColo {
+		labels["colo"] = colo
+	}
+	return labels
+}
+
+// groupRowsByColo splits the rows by colo, keeping only the maxColos colos
+// with the most minutes viewed and folding the rest into otherColo. Without
+// -group_by_colo everything lands in a single group.
+func groupRowsByColo(rows []cfStreamMinutesViewedGroup, maxColos int) map[string][]cfStreamMinutesViewedGroup {
+	groups := map[string][]cfStreamMinutesViewedGroup{}
+	if !cfgGroupByColo {
+		groups[""] = rows
+		return groups
+	}
+
+	totals := map[string]uint64{}
+	for _, r := range rows {
+		groups[r.Dimensions.Colo] = append(groups[r.Dimensions.Colo], r)
+		totals[r.Dimensions.Colo] += r.minutes()
+	}
+	if maxColos <= 0 || len(groups) <= maxColos {
+		return groups
+	}
+
+	colos := make([]string, 0, len(groups))
+	for c := range groups {
+		colos = append(colos, c)
+	}
+	sort.Slice(colos, func(i, j int) bool {
+		if totals[colos[i]] != totals[colos[j]] {
+			return totals[colos[i]] > totals[colos[j]]
+		}
+		return colos[i] < colos[j]
+	})
+
+	capped := map[string][]cfStreamMinutesViewedGroup{}
+	for i, c := range colos {
+		if i < maxColos {
+			capped[c] = groups[c]
+			continue
+		}
+		capped[otherColo] = append(capped[otherColo], groups[c]...)
+	}
+
+	return capped
+}
+
+func distinctBuckets(rows []cfStreamMinutesViewedGroup) int {
+	seen := map[time.Time]struct{}{}
+	for _, r := range rows {
+		seen[r.Dimensions.Ts] = struct{}{}
+	}
+	return len(seen)
+}
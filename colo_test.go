@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGroupByColo(t *testing.T) {
+	tests := []struct {
+		maxColos int
+		want     map[string]float64
+	}{
+		{maxColos: 0, want: map[string]float64{"AMS": 80, "LHR": 30, "FRA": 5}},
+		{maxColos: 3, want: map[string]float64{"AMS": 80, "LHR": 30, "FRA": 5}},
+		{maxColos: 1, want: map[string]float64{"AMS": 80, otherColo: 35}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("max_colos %d", tt.maxColos), func(t *testing.T) {
+			setConfig(t, &cfgGroupByColo, true)
+			setConfig(t, &cfgMaxColos, tt.maxColos)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetExportedColos(t)
+			resetMetrics(t)
+			m := newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics_colos.json"))
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			if q := m.requests("/graphql/")[0].query; !containsWord(q, "coloCode") {
+				t.Errorf("query does not group by coloCode:\n%s", q)
+			}
+			if got := countSeries(t, tenants.all(), "cloudflare_streaming_minutes_viewed"); got != len(tt.want) {
+				t.Errorf("got %d series, want %d", got, len(tt.want))
+			}
+			for colo, want := range tt.want {
+				got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", viewedLabels(testAccount(), colo))
+				if !ok || got != want {
+					t.Errorf("colo %s: got %v (exported %t), want %v", colo, got, ok, want)
+				}
+			}
+			if got, _ := gatheredValue(t, tenants.all(), "cloudflare_stream_distinct_colos", accountLabels(testAccount())); got != 3 {
+				t.Errorf("got cloudflare_stream_distinct_colos %v, want 3", got)
+			}
+		})
+	}
+}
+
+func TestStaleColosDeleted(t *testing.T) {
+	setConfig(t, &cfgGroupByColo, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetExportedColos(t)
+	resetMetrics(t)
+	newMockCloudflare(t,
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics_colos.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics_one_colo.json"),
+	)
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	if got := countSeries(t, tenants.all(), "cloudflare_streaming_minutes_viewed"); got != 1 {
+		t.Errorf("got %d series after LHR and FRA stopped serving, want 1", got)
+	}
+	if got, _ := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", viewedLabels(testAccount(), "AMS")); got != 60 {
+		t.Errorf("got %v for AMS, want 60", got)
+	}
+}
+
+func TestDistinctColos(t *testing.T) {
+	tests := []struct {
+		name     string
+		byColo   bool
+		file     string
+		exported bool
+		want     float64
+	}{
+		// 5 rows over 2 buckets and 3 colos.
+		{name: "several colos", byColo: true, file: "streaming_analytics_colos.json", exported: true, want: 3},
+		{name: "one colo", byColo: true, file: "streaming_analytics_one_colo.json", exported: true, want: 1},
+		{name: "not grouping", file: "streaming_analytics.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgGroupByColo, tt.byColo)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetExportedColos(t)
+			resetMetrics(t)
+			newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", tt.file))
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_distinct_colos", accountLabels(testAccount()))
+			if ok != tt.exported || got != tt.want {
+				t.Errorf("got distinct colos %v (exported %t), want %v (exported %t)", got, ok, tt.want, tt.exported)
+			}
+		})
+	}
+}
+
+func resetExportedColos(t *testing.T) {
+	exportedColos.Lock()
+	exportedColos.colos = map[string]map[string]bool{}
+	exportedColos.Unlock()
+	t.Cleanup(func() {
+		exportedColos.Lock()
+		exportedColos.colos = map[string]map[string]bool{}
+		exportedColos.Unlock()
+	})
+}
+
+func containsWord(s, word string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Registered by registerAccountMetrics when -emit_period_comparison is set.
+var cfMinutesViewedDelta *accountGaugeVec
+
+func registerDeltaMetric() {
+	cfMinutesViewedDelta = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_minutes_viewed_delta",
+		Help: "Minutes viewed over the query window minus the same window -comparison_offset earlier",
+	}, accountLabelNames(),
+	)
+}
+
+// windowTotals keeps the minutes viewed of the current window per account
+// until the period comparison of the same cycle consumes them.
+var windowTotals = struct {
+	sync.Mutex
+	minutes map[string]uint64
+}{minutes: map[string]uint64{}}
+
+func recordWindowTotal(account monitoredAccount, minutes uint64) {
+	if !cfgEmitPeriodComparison {
+		return
+	}
+
+	windowTotals.Lock()
+	defer windowTotals.Unlock()
+	windowTotals.minutes[accountKey(account)] = minutes
+}
+
+func takeWindowTotal(account monitoredAccount) (uint64, bool) {
+	windowTotals.Lock()
+	defer windowTotals.Unlock()
+
+	key := accountKey(account)
+	minutes, ok := windowTotals.minutes[key]
+	delete(windowTotals.minutes, key)
+	return minutes, ok
+}
+
+// fetchPeriodComparison queries the window -comparison_offset before the
+// current one and exports the difference. It is skipped when the current
+// window failed, so the delta never mixes windows of different cycles.
+func fetchPeriodComparison(ctx context.Context, account monitoredAccount, start, end time.Time) {
+	current, ok := takeWindowTotal(account)
+	if !ok {
+		return
+	}
+
+	r, err := fetchStreamingTotals(ctx, account, start.Add(-cfgComparisonOffset), end.Add(-cfgComparisonOffset))
+	if err != nil {
+		log.Errorf("Fetching the comparison window for %s: %s", account.Name, err)
+		return
+	}
+
+	var previous uint64
+	for _, a := range r.Viewer.Accounts {
+		previous += totalMinutes(nonNullRows(a.AccountStreamMinutesViewedAdaptiveGroupsSum))
+	}
+	cfMinutesViewedDelta.set(account, accountLabels(account), roundValue(float64(current)-float64(previous)))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPeriodComparison(t *testing.T) {
+	earlier := func(minutes string) mockFixture {
+		return mockFixture{method: http.MethodPost, path: "/graphql/", body: `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [
+			{"sum": {"minutesViewed": ` + minutes + `}, "dimensions": {"ts": "2022-08-31T10:00:00Z"}}]}]}}}`}
+	}
+	failing := mockFixture{method: http.MethodPost, path: "/graphql/", status: http.StatusInternalServerError, body: "upstream unavailable"}
+	tests := []struct {
+		name     string
+		current  mockFixture
+		earlier  mockFixture
+		exported bool
+		want     float64
+	}{
+		// The current window of the fixture totals 240 minutes.
+		{"more than earlier", graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"), earlier("100"), true, 140},
+		{"less than earlier", graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"), earlier("300"), true, -60},
+		{"current window fails", failing, earlier("100"), false, 0},
+		{"earlier window fails", graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"), failing, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgEmitPeriodComparison, true)
+			setConfig(t, &cfgMaxRetries, 0)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			m := newMockCloudflare(t, tt.current, tt.earlier)
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_minutes_viewed_delta", accountLabels(testAccount()))
+			if ok != tt.exported || got != tt.want {
+				t.Errorf("got delta %v (exported %t), want %v (exported %t)", got, ok, tt.want, tt.exported)
+			}
+
+			requests := m.requests("/graphql/")
+			if tt.current.status != 0 {
+				if len(requests) != 1 {
+					t.Errorf("got %d graphql requests, want no comparison query after the current window failed", len(requests))
+				}
+				return
+			}
+			if len(requests) != 2 {
+				t.Fatalf("got %d graphql requests, want the window and the one before", len(requests))
+			}
+			for _, v := range []string{"mintime", "maxtime"} {
+				current, _ := time.Parse(time.RFC3339Nano, requests[0].variables[v].(string))
+				previous, _ := time.Parse(time.RFC3339Nano, requests[1].variables[v].(string))
+				if offset := current.Sub(previous); offset != cfgComparisonOffset {
+					t.Errorf("got the %s of the comparison %s earlier, want -comparison_offset %s", v, offset, cfgComparisonOffset)
+				}
+			}
+		})
+	}
+}
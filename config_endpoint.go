@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/namsral/flag"
+	log "github.com/sirupsen/logrus"
+)
+
+// secretFlags are never shown by /config, only whether they are set.
+var secretFlags = []string{"cf_api_token"}
+
+const redacted = "<redacted>"
+
+// effectiveConfig returns the value every flag ended up with, after the
+// command line, environment, config files and -config_source.
+func effectiveConfig() map[string]string {
+	config := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = redactFlagValue(f.Name, f.Value.String())
+	})
+	return config
+}
+
+// redactFlagValue hides secret flags, the password of urls with user info,
+// e.g. a -pushgateway_url with basic auth, and the query parameter values of
+// urls, which may carry a token as in -remote_write_url.
+func redactFlagValue(name, value string) string {
+	if len(value) == 0 {
+		return value
+	}
+	if contains(secretFlags, name) {
+		return redacted
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return value
+	}
+	// url.UserPassword would escape the brackets of redacted, so the user
+	// info is put back by hand.
+	var userinfo string
+	if _, ok := u.User.Password(); ok {
+		userinfo = url.User(u.User.Username()).String() + ":" + redacted + "@"
+		u.User = nil
+	}
+	query := redactQuery(u.RawQuery)
+	u.RawQuery = ""
+	redactedURL := u.String()
+	if userinfo != "" {
+		if i := strings.Index(redactedURL, "//"); i >= 0 {
+			redactedURL = redactedURL[:i+2] + userinfo + redactedURL[i+2:]
+		}
+	}
+	if query != "" {
+		redactedURL += "?" + query
+	}
+	return redactedURL
+}
+
+// redactQuery keeps the parameter names so /config still shows which ones are
+// set.
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+	params := make([]string, 0, len(values))
+	for name := range values {
+		params = append(params, url.QueryEscape(name)+"="+redacted)
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(effectiveConfig()); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/namsral/flag"
+	log "github.com/sirupsen/logrus"
+)
+
+// configSource loads flag values from an external store, keyed by flag name.
+type configSource interface {
+	Load(ctx context.Context) (map[string]string, error)
+}
+
+func newConfigSource(name string) (configSource, error) {
+	switch name {
+	case "consul":
+		if len(cfgConsulKey) == 0 {
+			return nil, fmt.Errorf("-config_source=consul requires -consul_key")
+		}
+		return consulSource{addr: cfgConsulAddr, key: cfgConsulKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported -config_source %q, expected consul", name)
+	}
+}
+
+// consulSource reads the config from a single consul kv key, holding one
+// "name value" or "name=value" pair per line like a flag config file.
+type consulSource struct {
+	addr string
+	key  string
+}
+
+func (s consulSource) Load(ctx context.Context) (map[string]string, error) {
+	endpoint := strings.TrimSuffix(s.addr, "/") + "/v1/kv/" + strings.TrimPrefix(s.key, "/") + "?raw"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading consul key %s: %s", s.key, resp.Status)
+	}
+
+	return parseConfigValues(resp.Body)
+}
+
+func parseConfigValues(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// A name on its own enables a boolean flag.
+		name, value := line, "true"
+		if i := strings.IndexAny(line, "= \t"); i >= 0 {
+			name, value = line[:i], strings.TrimLeft(line[i:], "= \t")
+		}
+		values[name] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// applyConfigSource overrides the flags with the values of the source, flags
+// missing from it keep their command line or environment value.
+func applyConfigSource(ctx context.Context, source configSource) error {
+	values, err := source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		if flag.Lookup(name) == nil {
+			log.Warnf("Ignoring unknown flag %s from -config_source", name)
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("setting %s from -config_source: %w", name, err)
+		}
+	}
+
+	log.Infof("Loaded %d settings from -config_source", len(values))
+	return nil
+}
+
+// applyConfigFiles layers -config_file_override over -config_file. Command
+// line and environment values win over both, and since a file only sets flags
+// not set yet the override is read before the base.
+func applyConfigFiles(base, override string) error {
+	for _, path := range []string{override, base} {
+		if len(path) == 0 {
+			continue
+		}
+		if err := flag.CommandLine.ParseFile(path); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return nil
+}
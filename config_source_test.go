@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/namsral/flag"
+)
+
+// stubConsul serves kv as the raw values of a consul kv store.
+func stubConsul(t *testing.T, kv map[string]string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, raw := r.URL.Query()["raw"]; !raw {
+			t.Errorf("consul key read without ?raw: %s", r.URL)
+		}
+		value, ok := kv[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, value)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestConsulSource(t *testing.T) {
+	addr := stubConsul(t, map[string]string{
+		"/v1/kv/exporter/config": "# fleet defaults\nscrape_interval=2m\nlabel_by id\nbatch_accounts\n\nunknown_setting 1\n",
+		"/v1/kv/exporter/bad":    "scrape_interval soon\n",
+	})
+
+	tests := []struct {
+		name       string
+		key        string
+		wantErr    bool
+		wantValues map[string]string
+		interval   time.Duration
+		labelBy    string
+		batch      bool
+		// The flag value is undefined after a failed Set.
+		skipFlags bool
+	}{
+		{
+			name: "config", key: "exporter/config",
+			wantValues: map[string]string{"scrape_interval": "2m", "label_by": "id", "batch_accounts": "true", "unknown_setting": "1"},
+			interval:   2 * time.Minute, labelBy: "id", batch: true,
+		},
+		// Flags missing from the store keep their value.
+		{name: "missing key", key: "exporter/missing", wantErr: true, interval: time.Minute, labelBy: "both"},
+		{name: "invalid value", key: "/exporter/bad", wantErr: true, wantValues: map[string]string{"scrape_interval": "soon"}, skipFlags: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgConsulAddr, addr+"/")
+			setConfig(t, &cfgConsulKey, tt.key)
+			setConfig(t, &flag.CommandLine, flag.NewFlagSet("test", flag.ContinueOnError))
+			interval, labelBy, batch := time.Minute, "both", false
+			flag.DurationVar(&interval, "scrape_interval", interval, "")
+			flag.StringVar(&labelBy, "label_by", labelBy, "")
+			flag.BoolVar(&batch, "batch_accounts", batch, "")
+
+			source, err := newConfigSource("consul")
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, loadErr := source.Load(context.Background())
+			if loadErr == nil && !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("loaded %v, want %v", values, tt.wantValues)
+			}
+
+			err = applyConfigSource(context.Background(), source)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("applyConfigSource() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.skipFlags && (interval != tt.interval || labelBy != tt.labelBy || batch != tt.batch) {
+				t.Errorf("got scrape_interval %s, label_by %s, batch_accounts %t, want %s, %s, %t", interval, labelBy, batch, tt.interval, tt.labelBy, tt.batch)
+			}
+		})
+	}
+}
+
+func TestNewConfigSource(t *testing.T) {
+	setConfig(t, &cfgConsulKey, "")
+	if _, err := newConfigSource("consul"); err == nil {
+		t.Error("consul source accepted without -consul_key")
+	}
+	if _, err := newConfigSource("etcd"); err == nil {
+		t.Error("unsupported config source accepted")
+	}
+}
+
+func TestConfigFileLayering(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.conf")
+	override := filepath.Join(dir, "prod.conf")
+	if err := os.WriteFile(base, []byte("scrape_interval 2m\nlabel_by name\nconcurrency 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(override, []byte("# prod\nlabel_by id\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		args           []string
+		base, override string
+		wantErr        bool
+		wantInterval   time.Duration
+		wantLabelBy    string
+		wantConc       int
+	}{
+		{name: "base only", base: base, wantInterval: 2 * time.Minute, wantLabelBy: "name", wantConc: 2},
+		{name: "override wins per key", base: base, override: override, wantInterval: 2 * time.Minute, wantLabelBy: "id", wantConc: 2},
+		{name: "override only", override: override, wantInterval: time.Minute, wantLabelBy: "id", wantConc: 5},
+		{name: "command line wins", args: []string{"-label_by=both", "-concurrency=9"}, base: base, override: override, wantInterval: 2 * time.Minute, wantLabelBy: "both", wantConc: 9},
+		{name: "missing file", base: filepath.Join(dir, "missing.conf"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &flag.CommandLine, flag.NewFlagSet("test", flag.ContinueOnError))
+			interval, labelBy, concurrency := time.Minute, "both", 5
+			flag.DurationVar(&interval, "scrape_interval", interval, "")
+			flag.StringVar(&labelBy, "label_by", labelBy, "")
+			flag.IntVar(&concurrency, "concurrency", concurrency, "")
+			if err := flag.CommandLine.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyConfigFiles(tt.base, tt.override)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("applyConfigFiles() error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if interval != tt.wantInterval || labelBy != tt.wantLabelBy || concurrency != tt.wantConc {
+				t.Errorf("got scrape_interval %s, label_by %s, concurrency %d, want %s, %s, %d", interval, labelBy, concurrency, tt.wantInterval, tt.wantLabelBy, tt.wantConc)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// gatherer is what the metrics endpoint and -oneshot expose, it is wrapped
+// after flag parsing to add the -const_labels.
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
+func parseConstLabels(s string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for _, pair := range splitList(s) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid constant label %q, expected key=value", pair)
+		}
+
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid constant label name %q", name)
+		}
+		if !utf8.ValidString(value) {
+			return nil, fmt.Errorf("invalid constant label value %q for %s", value, name)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("duplicate constant label %q", name)
+		}
+		labels[name] = value
+	}
+
+	return labels, nil
+}
+
+// constLabelGatherer adds fixed labels to every gathered metric. The metrics
+// are registered at init time, before the labels are known from the flags,
+// so they are applied on the way out instead of in the metric opts.
+type constLabelGatherer struct {
+	next   prometheus.Gatherer
+	labels []*dto.LabelPair
+}
+
+func newConstLabelGatherer(next prometheus.Gatherer, labels prometheus.Labels) prometheus.Gatherer {
+	if len(labels) == 0 {
+		return next
+	}
+
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(k), Value: proto.String(v)})
+	}
+	return &constLabelGatherer{next: next, labels: pairs}
+}
+
+func (g *constLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.next.Gather()
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			for _, l := range g.labels {
+				for _, existing := range m.Label {
+					if existing.GetName() == l.GetName() {
+						return nil, fmt.Errorf("metric %s already has label %q set by -const_labels", mf.GetName(), l.GetName())
+					}
+				}
+				m.Label = append(m.Label, l)
+			}
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+
+	return families, err
+}
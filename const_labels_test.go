@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseConstLabels(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    prometheus.Labels
+		wantErr bool
+	}{
+		{raw: "", want: prometheus.Labels{}},
+		{raw: "region=eu, cluster=prod", want: prometheus.Labels{"region": "eu", "cluster": "prod"}},
+		{raw: "team=video=live", want: prometheus.Labels{"team": "video=live"}},
+		{raw: "region", wantErr: true},
+		{raw: "1region=eu", wantErr: true},
+		{raw: "__name__=x", wantErr: true},
+		{raw: "region=eu,region=us", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseConstLabels(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseConstLabels(%q) error = %v, want error %t", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseConstLabels(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestConstLabelsOnEveryMetric(t *testing.T) {
+	resetMetrics(t)
+	labels, err := parseConstLabels("region=eu,cluster=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, &gatherer, newConstLabelGatherer(allGatherers(), labels))
+	setMinutesViewed(testAccount(), 80)
+
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, want := range []string{
+		`cloudflare_streaming_minutes_viewed{account="Acme Streaming",account_id="023e105f4ecef8ad9ca31a8372d0c353",cluster="prod",region="eu"} 80`,
+		`cloudflare_stream_series_limit_exceeded{cluster="prod",region="eu"} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
+
+func TestConstLabelConflict(t *testing.T) {
+	resetMetrics(t)
+	setMinutesViewed(testAccount(), 80)
+
+	g := newConstLabelGatherer(tenants.all(), prometheus.Labels{"account": "override"})
+	if _, err := g.Gather(); err == nil {
+		t.Error("gathering a metric with an account label succeeded, want a conflict with -const_labels")
+	}
+}
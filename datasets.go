@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// datasetRegistries hold the metrics of each graphql dataset apart from the
+// exporter's own metrics, so with -dataset_paths each can be scraped by its
+// own job and interval. The main metrics path serves all of them.
+var datasetRegistries = map[string]*prometheus.Registry{
+	"viewed":  prometheus.NewRegistry(),
+	"videos":  prometheus.NewRegistry(),
+	"uniques": prometheus.NewRegistry(),
+}
+
+// allGatherers merges the default registry with the dataset and tenant
+// registries.
+func allGatherers() prometheus.Gatherer {
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, tenants.all()}
+	for _, reg := range datasetRegistries {
+		gatherers = append(gatherers, reg)
+	}
+	return gatherers
+}
+
+// registerDatasetPaths serves the metrics of each dataset at
+// <metrics_path>/<dataset>, from every account.
+func registerDatasetPaths(metricsPath string, constLabels prometheus.Labels) {
+	for name, reg := range datasetRegistries {
+		dataset := prometheus.Gatherers{reg, tenants.dataset(name)}
+		handler := promhttp.HandlerFor(newConstLabelGatherer(dataset, constLabels), promhttp.HandlerOpts{})
+		http.Handle(metricsPath+"/"+name, allowMethods(handler, readMethods...))
+	}
+}
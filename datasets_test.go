@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDatasetPaths(t *testing.T) {
+	setConfig(t, &cfgTopVideos, 2)
+	setConfig(t, &cfgUniqueViewers, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &videoNames, &videoNameCache{names: map[string]string{}})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+		graphqlFixture("StreamTopVideos", "top_videos.json"),
+		graphqlFixture("StreamUniqueViewers", "unique_viewers.json"),
+		restFixture(http.MethodGet, "/accounts/"+testAccount().ID+"/stream/ea95132c15732412d22c1476fa83f27a", "stream_video.json"),
+	)
+	setConfig(t, &http.DefaultServeMux, http.NewServeMux())
+	registerDatasetPaths("/metrics", nil)
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	metrics := map[string]string{
+		"viewed":  "cloudflare_streaming_minutes_viewed{",
+		"videos":  "cloudflare_stream_video_minutes_viewed{",
+		"uniques": "cloudflare_stream_unique_viewers{",
+	}
+	for dataset := range datasetRegistries {
+		t.Run(dataset, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/"+dataset, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", rec.Code)
+			}
+			body := rec.Body.String()
+			for other, metric := range metrics {
+				if got := strings.Contains(body, metric); got != (other == dataset) {
+					t.Errorf("got %s exposed %t, want only the %s metrics:\n%s", metric, got, dataset, body)
+				}
+			}
+			// The exporter's own metrics stay on the main path.
+			if strings.Contains(body, "cloudflare_stream_api_request_duration_seconds") || strings.Contains(body, "go_goroutines") {
+				t.Errorf("got exporter metrics on the %s path:\n%s", dataset, body)
+			}
+		})
+	}
+}
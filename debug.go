@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// maxDebugResponses bounds how many accounts keep their last response, the
+// oldest entry is evicted first.
+const maxDebugResponses = 100
+
+type debugAccountKey struct{}
+
+// responseCache keeps the last raw graphql response body per account. Only
+// bodies are stored, so no request headers such as the token end up in it.
+type responseCache struct {
+	mu     sync.Mutex
+	bodies map[string][]byte
+	order  []string
+}
+
+var lastResponses = &responseCache{bodies: map[string][]byte{}}
+
+func (c *responseCache) set(id string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.bodies[id]; !ok {
+		c.order = append(c.order, id)
+		if len(c.order) > maxDebugResponses {
+			delete(c.bodies, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.bodies[id] = body
+}
+
+func (c *responseCache) get(id string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	body, ok := c.bodies[id]
+	return body, ok
+}
+
+// withDebugAccount marks the graphql requests made with ctx as belonging to
+// the account, so their responses are kept for /debug/last_response.
+func withDebugAccount(ctx context.Context, id string) context.Context {
+	if !cfgEnableDebugEndpoints {
+		return ctx
+	}
+	return context.WithValue(ctx, debugAccountKey{}, id)
+}
+
+func recordLastResponse(ctx context.Context, body []byte) {
+	if id, ok := ctx.Value(debugAccountKey{}).(string); ok {
+		lastResponses.set(id, body)
+	}
+}
+
+func lastResponseHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("account")
+	if len(id) == 0 {
+		http.Error(w, "missing account parameter", http.StatusBadRequest)
+		return
+	}
+
+	body, ok := lastResponses.get(id)
+	if !ok {
+		http.Error(w, "no response recorded for account "+id, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
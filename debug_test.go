@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLastResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		target  string
+		status  int
+	}{
+		{"recorded", true, "/debug/last_response?account=" + testAccount().ID, http.StatusOK},
+		{"other account", true, "/debug/last_response?account=7c5dae5552338874e5053f2534d2767a", http.StatusNotFound},
+		{"missing account", true, "/debug/last_response", http.StatusBadRequest},
+		{"disabled", false, "/debug/last_response?account=" + testAccount().ID, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgEnableDebugEndpoints, tt.enabled)
+			setConfig(t, &lastResponses, &responseCache{bodies: map[string][]byte{}})
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			rec := httptest.NewRecorder()
+			lastResponseHandler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			body := rec.Body.String()
+			if body != readTestdata(t, "streaming_analytics.json") {
+				t.Errorf("got %s, want the raw graphql response", body)
+			}
+			if strings.Contains(body, testAccount().token.value) {
+				t.Error("the token leaked into the recorded response")
+			}
+		})
+	}
+}
+
+func TestResponseCacheBounded(t *testing.T) {
+	c := &responseCache{bodies: map[string][]byte{}}
+	for i := 0; i <= maxDebugResponses; i++ {
+		c.set(fmt.Sprint("account", i), []byte("{}"))
+	}
+	c.set("account1", []byte(`{"updated": true}`))
+
+	if _, ok := c.get("account0"); ok {
+		t.Error("the oldest account was not evicted")
+	}
+	if body, ok := c.get("account1"); !ok || string(body) != `{"updated": true}` {
+		t.Errorf("got %s (kept %t), want the updated response", body, ok)
+	}
+	if len(c.bodies) != maxDebugResponses {
+		t.Errorf("got %d responses, want at most %d", len(c.bodies), maxDebugResponses)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAccountsETag(t *testing.T) {
+	setConfig(t, &accountsETags, &etagCache{entries: map[string]etagEntry{}})
+	resetMetrics(t)
+	first := restFixture(http.MethodGet, "/accounts", "accounts.json")
+	first.header = http.Header{"Etag": {`"v1"`}}
+	notModified := restFixture(http.MethodGet, "/accounts", "")
+	notModified.status = http.StatusNotModified
+	m := newMockCloudflare(t, first, notModified)
+
+	want, err := fetchAccounts(context.Background(), testAccount().token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fetchAccounts(context.Background(), testAccount().token)
+	if err != nil {
+		t.Fatalf("fetchAccounts() on 304 = %s, want the cached list", err)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v on 304, want the cached %v", got, want)
+	}
+
+	other := apiToken{name: "token1", value: "other-token"}
+	if _, err := fetchAccounts(context.Background(), other); err == nil {
+		t.Error("another token got the cached list of the first one")
+	}
+
+	requests := m.requests("/client/v4/accounts")
+	if len(requests) != 3 {
+		t.Fatalf("got %d accounts requests, want 3", len(requests))
+	}
+	for i, want := range []string{"", `"v1"`, ""} {
+		if got := requests[i].header.Get("If-None-Match"); got != want {
+			t.Errorf("request %d sent If-None-Match %q, want %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registered by registerAccountMetrics once the account labels are known.
+var cfConsecutiveScrapeFailures *accountGaugeVec
+
+func registerFailureMetric() {
+	cfConsecutiveScrapeFailures = newAccountGaugeVec("", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_consecutive_scrape_failures",
+		Help: "Number of consecutive scrapes the graphql query of the account failed, 0 after a success",
+	}, accountLabelNames(),
+	)
+}
+
+// recordFetchResult bumps the consecutive failures of the account when err is
+// set and resets them otherwise.
+func recordFetchResult(account monitoredAccount, err error) {
+	if err != nil {
+		cfConsecutiveScrapeFailures.inc(account, accountLabels(account))
+		cycleSummary.addFailure()
+		return
+	}
+	cfConsecutiveScrapeFailures.set(account, accountLabels(account), 0)
+}
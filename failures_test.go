@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestConsecutiveScrapeFailures(t *testing.T) {
+	setConfig(t, &cfgMaxRetries, 0)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t)
+	failing := mockFixture{method: http.MethodPost, path: "/graphql/", status: http.StatusServiceUnavailable, body: "upstream unavailable"}
+	ok := graphqlFixture("StreamMinutesViewed", "")
+	ok.body = readTestdata(t, "streaming_analytics.json")
+
+	cycles := []struct {
+		fixture mockFixture
+		want    float64
+	}{
+		{failing, 1},
+		{failing, 2},
+		{failing, 3},
+		{ok, 0},
+		{failing, 1},
+		{ok, 0},
+	}
+	for i, cycle := range cycles {
+		m.mu.Lock()
+		m.fixtures = []mockFixture{cycle.fixture}
+		m.mu.Unlock()
+
+		fetchStreamingAnalytics(context.Background(), testAccount())
+
+		got, exported := gatheredValue(t, tenants.all(), "cloudflare_stream_consecutive_scrape_failures", accountLabels(testAccount()))
+		if !exported || got != cycle.want {
+			t.Errorf("cycle %d: got %v consecutive failures (exported %t), want %v", i, got, exported, cycle.want)
+		}
+	}
+}
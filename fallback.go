@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Registered by registerAccountMetrics once the account labels are known.
+var cfStreamUsingFallback *accountGaugeVec
+
+func registerFallbackMetric() {
+	cfStreamUsingFallback = newAccountGaugeVec("", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_using_fallback",
+		Help: "Whether the account metrics were served by the rest api because graphql failed",
+	}, accountLabelNames(),
+	)
+}
+
+type cfRESTStreamViews struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result struct {
+		Totals struct {
+			TotalTimeViewedMs float64 `json:"totalTimeViewedMs"`
+		} `json:"totals"`
+	} `json:"result"`
+}
+
+// fetchStreamingTotalsREST returns the minutes viewed between since and until
+// using the rest stream analytics api, which only provides a total. The
+// cloudflare client has no raw request taking a context, so it is sent on the
+// shared http client to stop with the scrape.
+func fetchStreamingTotalsREST(ctx context.Context, account monitoredAccount, since, until time.Time) (float64, error) {
+	ctx, span := tracer.Start(ctx, "fetchStreamingTotalsREST")
+	defer span.End()
+
+	if len(account.token.value) == 0 {
+		return 0, errNoAPIToken
+	}
+
+	params := url.Values{}
+	params.Set("metrics", "totalTimeViewedMs")
+	params.Set("since", since.UTC().Format(time.RFC3339))
+	params.Set("until", until.UTC().Format(time.RFC3339))
+
+	endpoint := fmt.Sprintf("%s/accounts/%s/stream/analytics/views?%s", cfAPIEndpoint, account.ID, params.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+account.token.value)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var views cfRESTStreamViews
+	if err := json.NewDecoder(resp.Body).Decode(&views); err != nil {
+		return 0, fmt.Errorf("decoding the stream views (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || !views.Success {
+		if len(views.Errors) > 0 {
+			return 0, fmt.Errorf("fetching the stream views: %s: %s (%d)", resp.Status, views.Errors[0].Message, views.Errors[0].Code)
+		}
+		return 0, fmt.Errorf("fetching the stream views: %s", resp.Status)
+	}
+
+	return views.Result.Totals.TotalTimeViewedMs / float64(time.Minute/time.Millisecond), nil
+}
+
+func fetchStreamingAnalyticsREST(ctx context.Context, account monitoredAccount, since, until time.Time) {
+	minutes, err := fetchStreamingTotalsREST(ctx, account, since, until)
+	if err != nil {
+		log.Errorf("Rest fallback for %s failed: %s", account.Name, err)
+		cfStreamUsingFallback.set(account, accountLabels(account), 0)
+		return
+	}
+
+	// The total has no colo to split by, so with -group_by_colo only the
+	// per-account rate is served and the colo series are left stale.
+	if !cfgGroupByColo {
+		// Match the graphql path, which reports the average per -granularity bucket.
+		buckets := float64(until.Sub(since) / cfgGranularity)
+		if buckets < 1 {
+			buckets = 1
+		}
+		setMinutesViewed(account, minutes/buckets)
+	}
+	cfMinutesViewedPerMinute.set(account, accountLabels(account), roundValue(minutes/until.Sub(since).Minutes()))
+	cfStreamUsingFallback.set(account, accountLabels(account), 1)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRESTFallback(t *testing.T) {
+	graphqlDown := mockFixture{method: http.MethodPost, path: "/graphql/", status: http.StatusServiceUnavailable, body: "upstream unavailable"}
+	viewsPath := "/accounts/" + testAccount().ID + "/stream/analytics/views"
+	restDown := restFixture(http.MethodGet, viewsPath, "rest_error.json")
+	restDown.status = http.StatusForbidden
+
+	tests := []struct {
+		name         string
+		fixtures     []mockFixture
+		groupByColo  bool
+		wantFallback float64
+		wantMinutes  float64
+		wantRate     float64
+		wantExported bool
+	}{
+		{
+			name:         "graphql works",
+			fixtures:     []mockFixture{graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")},
+			wantMinutes:  80,
+			wantExported: true,
+		},
+		{
+			// 300 minutes over the 6 buckets of the 30m window.
+			name:         "graphql fails",
+			fixtures:     []mockFixture{graphqlDown, restFixture(http.MethodGet, viewsPath, "rest_stream_views.json")},
+			wantFallback: 1,
+			wantMinutes:  50,
+			wantRate:     10,
+			wantExported: true,
+		},
+		{
+			// The total cannot be split by colo, only the rate is served.
+			name:         "graphql fails grouping by colo",
+			fixtures:     []mockFixture{graphqlDown, restFixture(http.MethodGet, viewsPath, "rest_stream_views.json")},
+			groupByColo:  true,
+			wantFallback: 1,
+			wantRate:     10,
+		},
+		{
+			name:     "both fail",
+			fixtures: []mockFixture{graphqlDown, restDown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgEnableRESTFallback, true)
+			setConfig(t, &cfgMaxRetries, 0)
+			setConfig(t, &cfgGroupByColo, tt.groupByColo)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, tt.fixtures...)
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			labels := accountLabels(testAccount())
+			if got, _ := gatheredValue(t, tenants.all(), "cloudflare_stream_using_fallback", labels); got != tt.wantFallback {
+				t.Errorf("got cloudflare_stream_using_fallback %v, want %v", got, tt.wantFallback)
+			}
+			if tt.wantFallback == 1 {
+				if got, _ := gatheredValue(t, tenants.all(), "cloudflare_stream_minutes_viewed_per_minute", labels); got != tt.wantRate {
+					t.Errorf("got minutes viewed per minute %v, want %v", got, tt.wantRate)
+				}
+			}
+			if tt.groupByColo {
+				if n := countSeries(t, tenants.all(), "cloudflare_streaming_minutes_viewed"); n != 0 {
+					t.Errorf("got %d minutes viewed series, want none without a colo", n)
+				}
+				return
+			}
+			got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", labels)
+			if ok != tt.wantExported || got != tt.wantMinutes {
+				t.Errorf("got minutes viewed %v (exported %t), want %v (exported %t)", got, ok, tt.wantMinutes, tt.wantExported)
+			}
+		})
+	}
+}
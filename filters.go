@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var errFiltersExcludeAll = errors.New("account filters exclude every account visible to the token")
+
+func splitList(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// included reports whether the account passes the include filters, which
+// are a union: matching any of them is enough, and no filter includes all.
+func included(a monitoredAccount, include []string, nameContains string) bool {
+	if len(include) == 0 && len(nameContains) == 0 {
+		return true
+	}
+	if contains(include, a.ID) {
+		return true
+	}
+
+	return len(nameContains) > 0 && strings.Contains(strings.ToLower(a.Name), strings.ToLower(nameContains))
+}
+
+const (
+	skipReasonInclude = "include_filter"
+	skipReasonExclude = "exclude_filter"
+	// Not a filter, set when the cycle hit -max_scrape_duration.
+	skipReasonDeadline = "scrape_deadline"
+)
+
+var (
+	cfAccountsSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudflare_stream_accounts_skipped_total",
+		Help: "Number of times an account was skipped by the account filters or the scrape deadline",
+	}, []string{"reason"},
+	)
+)
+
+func init() {
+	cfAccountsSkipped.WithLabelValues(skipReasonInclude)
+	cfAccountsSkipped.WithLabelValues(skipReasonExclude)
+	cfAccountsSkipped.WithLabelValues(skipReasonDeadline)
+}
+
+// filterAccounts applies -include_accounts, -include_accounts_url,
+// -include_accounts_contains and -exclude_accounts, exclusion wins when an
+// account matches both. It also returns how many accounts each filter skipped.
+func filterAccounts(accounts []monitoredAccount) ([]monitoredAccount, map[string]int, error) {
+	include := append(splitList(cfIncludeAccounts), remoteIncludes.get()...)
+	exclude := splitList(cfgExcludeAccounts)
+
+	// An allowlist that is empty or never loaded must not leave the include
+	// filters empty, which would include every account.
+	includeNone := len(cfgIncludeAccountsURL) > 0 && len(include) == 0 && len(cfgIncludeAccountsContains) == 0
+	if includeNone && len(accounts) > 0 {
+		log.Warnf("-include_accounts_url lists no accounts and no other include filter is set, skipping all %d accounts", len(accounts))
+	}
+
+	var monitored []monitoredAccount
+	skipped := map[string]int{}
+	for _, a := range accounts {
+		if includeNone || !included(a, include, cfgIncludeAccountsContains) {
+			skipped[skipReasonInclude]++
+			continue
+		}
+		if contains(exclude, a.ID) {
+			skipped[skipReasonExclude]++
+			continue
+		}
+		monitored = append(monitored, a)
+	}
+
+	if len(accounts) > 0 && len(monitored) == 0 {
+		log.Warnf("!!! %d accounts are visible but the account filters exclude all of them, nothing will be exported !!!", len(accounts))
+		if cfgStrictFilters {
+			return nil, skipped, errFiltersExcludeAll
+		}
+	}
+
+	return monitored, skipped, nil
+}
+
+// warnCancelingFilters flags accounts that are both included and excluded,
+// which always results in them being skipped.
+func warnCancelingFilters() {
+	exclude := splitList(cfgExcludeAccounts)
+	for _, id := range splitList(cfIncludeAccounts) {
+		if contains(exclude, id) {
+			log.Warnf("Account %s is in both -include_accounts and -exclude_accounts and will be skipped", id)
+		}
+	}
+}
+
+// checkFilters runs at startup. It warns about the included accounts no
+// token can see, whatever -strict_filters, and with -strict_filters exits
+// when the filters leave no account to monitor.
+func checkFilters(ctx context.Context) {
+	accounts, err := fetchAllAccounts(ctx)
+	if err != nil {
+		log.Warnf("Not checking the account filters: %s", err)
+		return
+	}
+	warnInvisibleIncludes(accounts)
+
+	if _, _, err := filterAccounts(accounts); errors.Is(err, errFiltersExcludeAll) {
+		log.Fatal(err)
+	}
+}
+
+// warnInvisibleIncludes flags -include_accounts IDs missing from the accounts
+// visible to the tokens, usually a typo or a token without access.
+func warnInvisibleIncludes(accounts []monitoredAccount) {
+	visible := map[string]bool{}
+	for _, a := range accounts {
+		visible[a.ID] = true
+	}
+	for _, id := range append(splitList(cfIncludeAccounts), remoteIncludes.get()...) {
+		if !visible[id] {
+			log.Warnf("Included account %s is not visible to the api tokens and will not be monitored", id)
+		}
+	}
+}
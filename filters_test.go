@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func testAccounts() []monitoredAccount {
+	return []monitoredAccount{
+		{Account: cloudflare.Account{ID: "a", Name: "Acme Streaming"}},
+		{Account: cloudflare.Account{ID: "b", Name: "Acme Staging"}},
+		{Account: cloudflare.Account{ID: "c", Name: "Other Corp"}},
+	}
+}
+
+func accountIDs(accounts []monitoredAccount) []string {
+	ids := []string{}
+	for _, a := range accounts {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestFiltersCancelingOut(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude string
+		strict           bool
+		accounts         []monitoredAccount
+		wantErr          error
+		wantIDs          []string
+	}{
+		{name: "cancel out", include: "a", exclude: "a", accounts: testAccounts(), wantIDs: []string{}},
+		{name: "cancel out strict", include: "a", exclude: "a", strict: true, accounts: testAccounts(), wantErr: errFiltersExcludeAll},
+		{name: "exclude everything strict", exclude: "a,b,c", strict: true, accounts: testAccounts(), wantErr: errFiltersExcludeAll},
+		{name: "nothing visible strict", include: "a", strict: true, wantIDs: []string{}},
+		{name: "partial overlap strict", include: "a,b", exclude: "a", strict: true, accounts: testAccounts(), wantIDs: []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfIncludeAccounts, tt.include)
+			setConfig(t, &cfgExcludeAccounts, tt.exclude)
+			setConfig(t, &cfgStrictFilters, tt.strict)
+
+			monitored, _, err := filterAccounts(tt.accounts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("filterAccounts() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := accountIDs(monitored); !equalStrings(got, tt.wantIDs) {
+				t.Errorf("filterAccounts() = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIncludeAccountsContains(t *testing.T) {
+	tests := []struct {
+		name         string
+		include      string
+		nameContains string
+		exclude      string
+		wantIDs      []string
+	}{
+		{name: "no filter", wantIDs: []string{"a", "b", "c"}},
+		{name: "substring", nameContains: "acme", wantIDs: []string{"a", "b"}},
+		{name: "case-insensitive", nameContains: "STAG", wantIDs: []string{"b"}},
+		{name: "union with exact include", include: "c", nameContains: "staging", wantIDs: []string{"b", "c"}},
+		{name: "exclusion wins", nameContains: "acme", exclude: "a", wantIDs: []string{"b"}},
+		{name: "no match", nameContains: "nobody", wantIDs: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfIncludeAccounts, tt.include)
+			setConfig(t, &cfgIncludeAccountsContains, tt.nameContains)
+			setConfig(t, &cfgExcludeAccounts, tt.exclude)
+
+			monitored, _, err := filterAccounts(testAccounts())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := accountIDs(monitored); !equalStrings(got, tt.wantIDs) {
+				t.Errorf("filterAccounts() = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestAccountsSkipped(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	tests := []struct {
+		name         string
+		include      string
+		nameContains string
+		exclude      string
+		wantInclude  float64
+		wantExclude  float64
+	}{
+		{name: "no filter"},
+		{name: "include", include: testAccount().ID, wantInclude: 1},
+		{name: "include contains", nameContains: "staging", wantInclude: 1},
+		{name: "exclude", exclude: staging, wantExclude: 1},
+		{name: "include and exclude", include: testAccount().ID + "," + staging, exclude: staging, wantExclude: 1},
+		{name: "both filters skip", include: testAccount().ID, exclude: testAccount().ID, wantInclude: 1, wantExclude: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfIncludeAccounts, tt.include)
+			setConfig(t, &cfgIncludeAccountsContains, tt.nameContains)
+			setConfig(t, &cfgExcludeAccounts, tt.exclude)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t,
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+			)
+			include := testutil.ToFloat64(cfAccountsSkipped.WithLabelValues(skipReasonInclude))
+			exclude := testutil.ToFloat64(cfAccountsSkipped.WithLabelValues(skipReasonExclude))
+
+			fetchMetrics(context.Background())
+
+			if got := testutil.ToFloat64(cfAccountsSkipped.WithLabelValues(skipReasonInclude)) - include; got != tt.wantInclude {
+				t.Errorf("got %v accounts skipped by the include filters, want %v", got, tt.wantInclude)
+			}
+			if got := testutil.ToFloat64(cfAccountsSkipped.WithLabelValues(skipReasonExclude)) - exclude; got != tt.wantExclude {
+				t.Errorf("got %v accounts skipped by the exclude filter, want %v", got, tt.wantExclude)
+			}
+		})
+	}
+}
+
+func TestInvisibleIncludesWarned(t *testing.T) {
+	const missing = "deadbeefdeadbeefdeadbeefdeadbeef"
+	tests := []struct {
+		name    string
+		include string
+		remote  []string
+		want    []string
+	}{
+		{"all visible", testAccount().ID, nil, nil},
+		{"missing include", testAccount().ID + "," + missing, nil, []string{missing}},
+		{"missing remote include", "", []string{missing}, []string{missing}},
+		{"no filter", "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfIncludeAccounts, tt.include)
+			setConfig(t, &remoteIncludes, &remoteAllowlist{ids: tt.remote})
+			setConfig(t, &cfgStrictFilters, false)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"))
+			hook := test.NewGlobal()
+			t.Cleanup(func() { log.StandardLogger().ReplaceHooks(log.LevelHooks{}) })
+
+			checkFilters(context.Background())
+
+			var got []string
+			for _, e := range hook.AllEntries() {
+				if e.Level == log.WarnLevel && strings.Contains(e.Message, "not visible") {
+					got = append(got, strings.Fields(e.Message)[2])
+				}
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("got warnings for %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var errResponseTooLarge = errors.New("response exceeds -max_response_bytes")
+
+var cfGraphQLErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cloudflare_stream_graphql_errors_total",
+	Help: "Errors returned by the cloudflare graphql api, by extensions.code",
+}, []string{"code"},
+)
+
+type cfGraphQLErrorResponse struct {
+	Errors []struct {
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// readResponseBody reads the whole body and puts it back for the graphql
+// client. Bodies over -max_response_bytes are rejected so an anomalous
+// response cannot exhaust memory.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cfgMaxResponseBytes+1))
+	resp.Body.Close()
+	if err == nil && int64(len(body)) > cfgMaxResponseBytes {
+		return nil, fmt.Errorf("%w: %d bytes", errResponseTooLarge, cfgMaxResponseBytes)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// countGraphQLErrors counts the error codes of a graphql response body. The
+// client only surfaces the message of the first error, so the codes are read
+// here.
+func countGraphQLErrors(body []byte) {
+	var parsed cfGraphQLErrorResponse
+	if json.Unmarshal(body, &parsed) != nil {
+		return
+	}
+	for _, e := range parsed.Errors {
+		code := e.Extensions.Code
+		if len(code) == 0 {
+			code = "unknown"
+		}
+		cfGraphQLErrors.WithLabelValues(code).Inc()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGraphQLErrorCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture mockFixture
+		want    map[string]float64
+	}{
+		{"error codes", graphqlFixture("StreamMinutesViewed", "graphql_error.json"), map[string]float64{"budgetExceeded": 1, "authz": 1, "unknown": 0}},
+		{"error without a code", mockFixture{method: "POST", path: "/graphql/", body: `{"data": null, "errors": [{"message": "boom"}]}`}, map[string]float64{"budgetExceeded": 0, "authz": 0, "unknown": 1}},
+		{"no errors", graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"), map[string]float64{"budgetExceeded": 0, "authz": 0, "unknown": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, tt.fixture)
+			before := map[string]float64{}
+			for code := range tt.want {
+				before[code] = testutil.ToFloat64(cfGraphQLErrors.WithLabelValues(code))
+			}
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			for code, want := range tt.want {
+				if got := testutil.ToFloat64(cfGraphQLErrors.WithLabelValues(code)) - before[code]; got != want {
+					t.Errorf("code %s counted %v times, want %v", code, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	size := int64(len(readTestdata(t, "streaming_analytics.json")))
+	tests := []struct {
+		name     string
+		limit    int64
+		wantErr  float64
+		exported bool
+	}{
+		{"oversized", 100, 1, false},
+		{"one byte over", size - 1, 1, false},
+		{"exactly the limit", size, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgMaxResponseBytes, tt.limit)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+			before := testutil.ToFloat64(cfGraphQLErrors.WithLabelValues("responseTooLarge"))
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			if got := testutil.ToFloat64(cfGraphQLErrors.WithLabelValues("responseTooLarge")) - before; got != tt.wantErr {
+				t.Errorf("responseTooLarge counted %v times, want %v", got, tt.wantErr)
+			}
+			if _, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(testAccount())); ok != tt.exported {
+				t.Errorf("minutes viewed exported = %t, want %t", ok, tt.exported)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nelkinda/health-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	cloudflareHealthTimeout = 5 * time.Second
+	// Probes usually come every few seconds, the api is asked at most this
+	// often.
+	cloudflareHealthCacheTTL = 10 * time.Second
+)
+
+// registerHealthEndpoint serves /health unless -disable_health_endpoint is
+// set.
+func registerHealthEndpoint() {
+	if cfgDisableHealthEndpoint {
+		if cfgHealthChecksCloudflare {
+			log.Warn("-health_checks_cloudflare has no effect with -disable_health_endpoint")
+		}
+		return
+	}
+
+	var checks []health.ChecksProvider
+	if cfgHealthChecksCloudflare {
+		checks = append(checks, &cloudflareHealth{})
+	}
+	h := health.New(health.Health{}, checks...)
+	http.Handle(route("/health"), allowMethods(http.HandlerFunc(h.Handler), readMethods...))
+}
+
+// cloudflareHealth checks the cloudflare api is reachable by verifying the
+// first token, a cheap call, and reports the latency.
+type cloudflareHealth struct {
+	mu      sync.Mutex
+	checked time.Time
+	checks  map[string][]health.Checks
+}
+
+func (c *cloudflareHealth) HealthChecks() map[string][]health.Checks {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < cloudflareHealthCacheTTL {
+		return c.checks
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cloudflareHealthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	check := health.Checks{
+		ComponentID:   "api.cloudflare.com",
+		ComponentType: "system",
+		ObservedUnit:  "ms",
+		Status:        health.Pass,
+		Time:          start.UTC().Format(time.RFC3339Nano),
+	}
+	err := verifyAPIToken(ctx, apiTokens[0])
+	check.ObservedValue = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Status = health.Fail
+		check.Output = err.Error()
+	}
+
+	c.checked = time.Now()
+	c.checks = map[string][]health.Checks{"cloudflare:responseTime": {check}}
+	return c.checks
+}
+
+// verifyAPIToken asks the api whether token is valid. It bypasses the rest
+// client, whose retries would outlast the probe.
+func verifyAPIToken(ctx context.Context, token apiToken) error {
+	if len(token.value) == 0 {
+		return errNoAPIToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfAPIEndpoint+"/user/tokens/verify", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.value)
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verifying the api token: %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *cloudflareHealth) AuthorizeHealth(r *http.Request) bool {
+	return true
+}
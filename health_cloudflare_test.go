@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nelkinda/health-go"
+)
+
+func TestCloudflareHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture mockFixture
+		want    health.Status
+	}{
+		{"healthy", restFixture(http.MethodGet, "/user/tokens/verify", "token_verify.json"), health.Pass},
+		{"unauthorized", mockFixture{method: http.MethodGet, path: "/client/v4/user/tokens/verify", status: http.StatusUnauthorized, file: "rest_error.json"}, health.Fail},
+		{"timeout", mockFixture{method: http.MethodGet, path: "/client/v4/user/tokens/verify", delay: 2 * cloudflareHealthTimeout, file: "token_verify.json"}, health.Fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			m := newMockCloudflare(t, tt.fixture)
+			c := &cloudflareHealth{}
+
+			start := time.Now()
+			checks := c.HealthChecks()["cloudflare:responseTime"]
+			if elapsed := time.Since(start); elapsed > cloudflareHealthTimeout+time.Second {
+				t.Errorf("check took %s, want it bounded by %s", elapsed, cloudflareHealthTimeout)
+			}
+			if len(checks) != 1 {
+				t.Fatalf("got checks %v, want one cloudflare check", checks)
+			}
+			check := checks[0]
+			if check.Status != tt.want {
+				t.Errorf("got status %s (output %q), want %s", check.Status, check.Output, tt.want)
+			}
+			if tt.want == health.Fail && len(check.Output) == 0 {
+				t.Error("got a failing check without output")
+			}
+			if check.ObservedUnit != "ms" || check.ObservedValue == nil {
+				t.Errorf("got observed %v %s, want the latency in ms", check.ObservedValue, check.ObservedUnit)
+			}
+
+			// Probes within the cache ttl get the cached result.
+			c.HealthChecks()
+			if got := len(m.requests("/client/v4/user/tokens/verify")); got != 1 {
+				t.Errorf("got %d verify requests for two probes, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDisableHealthEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		status   int
+	}{
+		{"enabled", false, http.StatusOK},
+		{"disabled", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgDisableHealthEndpoint, tt.disabled)
+			setConfig(t, &http.DefaultServeMux, http.NewServeMux())
+
+			registerHealthEndpoint()
+
+			rec := httptest.NewRecorder()
+			http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/machinebox/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	historicalDateFormat = "2006-01-02"
+	historicalPageSize   = 1000
+)
+
+// Registered by registerAccountMetrics when -historical_window is set.
+var cfHistoricalMinutesViewed *accountGaugeVec
+
+func registerHistoricalMetric() {
+	cfHistoricalMinutesViewed = newAccountGaugeVec("", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_historical_minutes_viewed",
+		Help: "Minutes viewed per day over -historical_window",
+	}, append(accountLabelNames(), "day"),
+	)
+}
+
+type cfResponseHistoricalStreaming struct {
+	Viewer struct {
+		Accounts []struct {
+			Days []struct {
+				Sum struct {
+					MinutesViewed *jsonUint64 `json:"minutesViewed"`
+				} `json:"sum"`
+				Dimensions struct {
+					Date string `json:"date"`
+				} `json:"dimensions"`
+			} `json:"streamMinutesViewedAdaptiveGroups"`
+		} `json:"accounts"`
+	} `json:"viewer"`
+}
+
+// buildHistoricalQuery queries -stream_dataset and -stream_field per day,
+// aliased like buildStreamingQuery.
+func buildHistoricalQuery() string {
+	return fmt.Sprintf(`
+	query %s($accountID: String!, $mindate: Date!, $maxdate: Date!, $limit: Int!) {
+		viewer {
+			accounts(filter: {accountTag: $accountID} ) {
+				streamMinutesViewedAdaptiveGroups: %s(limit: $limit, orderBy: [date_ASC], filter: { date_geq: $mindate, date_leq: $maxdate}) {
+					sum {
+						minutesViewed: %s
+					}
+
+					dimensions {
+						date
+					}
+				}
+			}
+		}
+	}
+`, operationName("StreamHistoricalMinutesViewed"), cfgStreamDataset, cfgStreamField)
+}
+
+// fetchHistoricalMinutes returns the minutes viewed per day between since and
+// until. Results come back in date order, so when a page is full the next one
+// starts the day after the last date received.
+func fetchHistoricalMinutes(ctx context.Context, account monitoredAccount, since, until time.Time) (map[string]uint64, error) {
+	ctx, span := tracer.Start(ctx, "fetchHistoricalMinutes")
+	defer span.End()
+
+	graphqlClient := graphql.NewClient(cfGraphQLEndpoint, graphql.WithHTTPClient(apiClient))
+	days := map[string]uint64{}
+	from := since.Format(historicalDateFormat)
+	to := until.Format(historicalDateFormat)
+	for {
+		request := graphql.NewRequest(buildHistoricalQuery())
+		request.Header.Set("Authorization", "Bearer "+account.token.value)
+		request.Var("accountID", account.ID)
+		request.Var("mindate", from)
+		request.Var("maxdate", to)
+		request.Var("limit", historicalPageSize)
+
+		var resp cfResponseHistoricalStreaming
+		if err := graphqlClient.Run(ctx, request, &resp); err != nil {
+			return nil, err
+		}
+
+		rows := 0
+		last := ""
+		for _, a := range resp.Viewer.Accounts {
+			for _, d := range a.Days {
+				last = d.Dimensions.Date
+				rows++
+				if d.Sum.MinutesViewed == nil {
+					log.Debugf("Skipping day %s with null minutes viewed", d.Dimensions.Date)
+					continue
+				}
+				days[d.Dimensions.Date] += uint64(*d.Sum.MinutesViewed)
+			}
+		}
+		if rows < historicalPageSize || len(last) == 0 {
+			return days, nil
+		}
+
+		lastDay, err := time.Parse(historicalDateFormat, last)
+		if err != nil {
+			return nil, err
+		}
+		from = lastDay.AddDate(0, 0, 1).Format(historicalDateFormat)
+		if from > to {
+			return days, nil
+		}
+	}
+}
+
+func fetchHistoricalMetrics(ctx context.Context, window time.Duration) error {
+	accounts, err := monitoredAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	until := time.Now().UTC()
+	since := until.Add(-window)
+	for _, a := range accounts {
+		log.Printf("Fetching %s of historical streaming analytics for %s", window, a.Name)
+		days, err := fetchHistoricalMinutes(ctx, a, since, until)
+		if err != nil {
+			log.Errorf("Fetching historical analytics for %s: %s", a.Name, err)
+			continue
+		}
+
+		for day, minutes := range days {
+			labels := accountLabels(a)
+			labels["day"] = day
+			cfHistoricalMinutesViewed.set(a, labels, float64(minutes)*viewedUnitMultiplier)
+		}
+	}
+
+	return nil
+}
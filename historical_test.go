@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFetchHistoricalMetrics(t *testing.T) {
+	setConfig(t, &cfgHistoricalWindow, 96*time.Hour)
+	setConfig(t, &cfIncludeAccounts, testAccount().ID)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamHistoricalMinutesViewed", "historical.json"),
+	)
+
+	if err := fetchHistoricalMetrics(context.Background(), cfgHistoricalWindow); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"2022-08-30": 1200, "2022-08-31": 950, "2022-09-02": 40}
+	if got := countSeries(t, tenants.all(), "cloudflare_stream_historical_minutes_viewed"); got != len(want) {
+		t.Errorf("got %d days, want %d without the null one", got, len(want))
+	}
+	for day, minutes := range want {
+		labels := accountLabels(testAccount())
+		labels["day"] = day
+		if got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_historical_minutes_viewed", labels); !ok || got != minutes {
+			t.Errorf("day %s: got %v (exported %t), want %v", day, got, ok, minutes)
+		}
+	}
+}
+
+func TestFetchHistoricalMinutesPages(t *testing.T) {
+	// A full first page makes the exporter ask again from the next day.
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	page := func(from time.Time, days int) string {
+		type row struct {
+			Sum struct {
+				MinutesViewed int `json:"minutesViewed"`
+			} `json:"sum"`
+			Dimensions struct {
+				Date string `json:"date"`
+			} `json:"dimensions"`
+		}
+		rows := make([]row, days)
+		for i := range rows {
+			rows[i].Sum.MinutesViewed = 1
+			rows[i].Dimensions.Date = from.AddDate(0, 0, i).Format(historicalDateFormat)
+		}
+		body, err := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"viewer": map[string]interface{}{
+			"accounts": []interface{}{map[string]interface{}{"streamMinutesViewedAdaptiveGroups": rows}},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	firstPage := graphqlFixture("StreamHistoricalMinutesViewed", "")
+	firstPage.body = page(first, historicalPageSize)
+	secondPage := graphqlFixture("StreamHistoricalMinutesViewed", "")
+	secondPage.body = page(first.AddDate(0, 0, historicalPageSize), 5)
+	m := newMockCloudflare(t, firstPage, secondPage)
+
+	until := first.AddDate(0, 0, historicalPageSize+10)
+	days, err := fetchHistoricalMinutes(context.Background(), testAccount(), first, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != historicalPageSize+5 {
+		t.Errorf("got %d days, want %d", len(days), historicalPageSize+5)
+	}
+
+	requests := m.requests("/graphql/")
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	if got, want := requests[1].variables["mindate"], first.AddDate(0, 0, historicalPageSize).Format(historicalDateFormat); got != want {
+		t.Errorf("second page starts at %v, want %s", got, want)
+	}
+}
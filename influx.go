@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func influxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// influxFields maps a prometheus sample to line protocol fields, histograms
+// and summaries are reduced to their sum and count.
+func influxFields(mf *dto.MetricFamily, m *dto.Metric) string {
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return "value=" + influxFloat(m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return "value=" + influxFloat(m.GetGauge().GetValue())
+	case dto.MetricType_SUMMARY:
+		return fmt.Sprintf("count=%di,sum=%s", m.GetSummary().GetSampleCount(), influxFloat(m.GetSummary().GetSampleSum()))
+	case dto.MetricType_HISTOGRAM:
+		return fmt.Sprintf("count=%di,sum=%s", m.GetHistogram().GetSampleCount(), influxFloat(m.GetHistogram().GetSampleSum()))
+	default:
+		return "value=" + influxFloat(m.GetUntyped().GetValue())
+	}
+}
+
+// writeInflux writes the families as influxdb line protocol, samples without
+// an explicit timestamp are stamped with now.
+func writeInflux(w io.Writer, families []*dto.MetricFamily, now time.Time) error {
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := m.GetLabel()
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+
+			var b strings.Builder
+			b.WriteString(influxEscaper.Replace(mf.GetName()))
+			for _, l := range labels {
+				if len(l.GetValue()) == 0 {
+					continue
+				}
+				b.WriteString(",")
+				b.WriteString(influxEscaper.Replace(l.GetName()))
+				b.WriteString("=")
+				b.WriteString(influxEscaper.Replace(l.GetValue()))
+			}
+
+			ts := now
+			if m.TimestampMs != nil {
+				ts = time.UnixMilli(m.GetTimestampMs())
+			}
+
+			if _, err := fmt.Fprintf(w, "%s %s %d\n", b.String(), influxFields(mf, m), ts.UnixNano()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteInflux(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	viewed := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cloudflare_streaming_minutes_viewed"}, []string{"account", "colo"})
+	viewed.WithLabelValues("Acme Streaming, EU", "").Set(80.5)
+	cycles := prometheus.NewCounter(prometheus.CounterOpts{Name: "cloudflare_stream_scrape_cycles_total"})
+	cycles.Add(3)
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "cloudflare_stream_api_request_duration_seconds", Buckets: []float64{1}})
+	latency.Observe(0.25)
+	latency.Observe(0.5)
+	reg.MustRegister(viewed, cycles, latency)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1662026400, 0)
+	var out bytes.Buffer
+	if err := writeInflux(&out, families, now); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `cloudflare_stream_api_request_duration_seconds count=2i,sum=0.75 1662026400000000000
+cloudflare_stream_scrape_cycles_total value=3 1662026400000000000
+cloudflare_streaming_minutes_viewed,account=Acme\ Streaming\,\ EU value=80.5 1662026400000000000
+`
+	if got := out.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteInfluxSampleTimestamp(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	collector := newBucketCollector("cloudflare_streaming_minutes_viewed", "help", []string{"account"})
+	ts := time.Date(2022, 9, 1, 10, 10, 0, 0, time.UTC)
+	collector.set(prometheus.Labels{"account": "acme"}, 30, ts)
+	reg.MustRegister(collector)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeInflux(&out, families, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "cloudflare_streaming_minutes_viewed,account=acme value=30 1662027000000000000\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want the bucket timestamp in %q", got, want)
+	}
+}
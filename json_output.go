@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type jsonSample struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"help"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  *float64          `json:"value,omitempty"`
+	Count  *uint64           `json:"count,omitempty"`
+	Sum    *float64          `json:"sum,omitempty"`
+
+	TimestampMs int64 `json:"timestamp_ms,omitempty"`
+}
+
+// jsonFloat keeps NaN and infinities out of the output, which encoding/json
+// cannot represent.
+func jsonFloat(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// writeJSON writes one flat entry per sample, histograms and summaries are
+// reduced to their sum and count like in the influx output.
+func writeJSON(w io.Writer, families []*dto.MetricFamily) error {
+	samples := []jsonSample{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			s := jsonSample{
+				Name:        mf.GetName(),
+				Help:        mf.GetHelp(),
+				Type:        mf.GetType().String(),
+				Labels:      map[string]string{},
+				TimestampMs: m.GetTimestampMs(),
+			}
+			for _, l := range m.GetLabel() {
+				s.Labels[l.GetName()] = l.GetValue()
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				s.Value = jsonFloat(m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				s.Value = jsonFloat(m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				count := m.GetSummary().GetSampleCount()
+				s.Count, s.Sum = &count, jsonFloat(m.GetSummary().GetSampleSum())
+			case dto.MetricType_HISTOGRAM:
+				count := m.GetHistogram().GetSampleCount()
+				s.Count, s.Sum = &count, jsonFloat(m.GetHistogram().GetSampleSum())
+			default:
+				s.Value = jsonFloat(m.GetUntyped().GetValue())
+			}
+			samples = append(samples, s)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(samples)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOneshotJSON(t *testing.T) {
+	setConfig(t, &cfgOutputFormat, "json")
+	setConfig(t, &cfIncludeAccounts, testAccount().ID)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	var out bytes.Buffer
+	if err := runOneshot(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var samples []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &samples); err != nil {
+		t.Fatalf("output is not a json array: %s", err)
+	}
+	want := map[string]interface{}{
+		"name":   "cloudflare_streaming_minutes_viewed",
+		"help":   "Number of minutes viewed by a user",
+		"type":   "GAUGE",
+		"labels": map[string]interface{}{"account": "Acme Streaming", "account_id": testAccount().ID},
+		"value":  80.0,
+	}
+	for _, s := range samples {
+		if s["name"] == want["name"] {
+			if !reflect.DeepEqual(s, want) {
+				t.Errorf("got %v, want %v", s, want)
+			}
+			return
+		}
+	}
+	t.Errorf("no %s sample in %s", want["name"], out.String())
+}
+
+func TestWriteJSONNaN(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "ratio", Help: "help"})
+	gauge.Set(math.NaN())
+	reg.MustRegister(gauge)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeJSON(&out, families); err != nil {
+		t.Fatal(err)
+	}
+	var samples []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &samples); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := samples[0]["value"]; len(samples) != 1 || ok {
+		t.Errorf("got %v, want the NaN value left out", samples)
+	}
+}
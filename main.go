@@ -2,29 +2,120 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/machinebox/graphql"
 	"github.com/namsral/flag"
-	"github.com/nelkinda/health-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 var (
 	cfGraphQLEndpoint = "https://api.cloudflare.com/client/v4/graphql/"
+	cfAPIEndpoint     = "https://api.cloudflare.com/client/v4"
 )
 
 var (
-	cfgListen         = ":8080"
-	cfgCfAPIToken     = ""
-	cfgMetricsPath    = "/metrics"
-	cfIncludeAccounts = ""
+	cfgListen                     = ":8080"
+	cfgListenNetwork              = "tcp"
+	cfgCfAPIToken                 = ""
+	cfgCfAPITokenNames            = ""
+	cfgMetricsPath                = "/metrics"
+	cfgScrapeInterval             = 60 * time.Second
+	cfgMaxSeries                  = 10000
+	cfgOtelEndpoint               = ""
+	cfgViewedUnit                 = "minutes"
+	cfgSmokeTest                  = false
+	cfgAlignToInterval            = false
+	cfgEnableRESTFallback         = false
+	cfgUseBucketTimestamps        = false
+	cfgConcurrency                = 4
+	cfgRequestTimeout             = 30 * time.Second
+	cfgGroupByColo                = false
+	cfgMaxColos                   = 20
+	cfgOneshot                    = false
+	cfgHistoricalWindow           = time.Duration(0)
+	cfgExcludeAccounts            = ""
+	cfgStrictFilters              = false
+	cfgOutputFormat               = "prometheus"
+	cfgAccountIDs                 = ""
+	cfgConstLabels                = ""
+	cfgIncludeAccountsContains    = ""
+	cfgPushgatewayURL             = ""
+	cfgPushgatewayJob             = "cloudflare_stream_exporter"
+	cfgShutdownTimeout            = 15 * time.Second
+	cfgAccountsPageSize           = 50
+	cfgMaxRetries                 = 3
+	cfgRetryBackoff               = time.Second
+	cfgMaxRetryDuration           = time.Duration(0)
+	cfgCfRateLimit                = 4.0
+	cfgCfMaxRetries               = 3
+	cfgBatchAccounts              = false
+	cfgScrapeSLO                  = time.Duration(0)
+	cfgLabelBy                    = "both"
+	cfgGraphQLOperationPrefix     = ""
+	cfgTopVideos                  = 0
+	cfgScrapeSchedule             = ""
+	cfgLookback                   = 30 * time.Minute
+	cfgGranularity                = 5 * time.Minute
+	cfgConfigSource               = ""
+	cfgConsulAddr                 = "http://127.0.0.1:8500"
+	cfgConsulKey                  = ""
+	cfgMetricsFile                = ""
+	cfgEnableDebugEndpoints       = false
+	cfgValuePrecision             = -1
+	cfgUniqueViewers              = false
+	cfgCAFile                     = ""
+	cfgGraphQLLimit               = 1000
+	cfgIncludeAccountsURL         = ""
+	cfgUnknownAccountName         = "{id}"
+	cfgMinMinutesViewed           = 0.0
+	cfgRemoteWriteURL             = ""
+	cfgSortAccounts               = ""
+	cfgMaxResponseBytes           = int64(64 << 20)
+	cfgConfigFile                 = ""
+	cfgConfigFileOverride         = ""
+	cfgAccountAliasFile           = ""
+	cfgStreamDataset              = "streamMinutesViewedAdaptiveGroups"
+	cfgStreamField                = "minutesViewed"
+	cfgHistogramBuckets           = ""
+	cfgNativeHistograms           = false
+	cfgRequireAllAccountsHaveData = false
+	cfgUnifiedMetrics             = false
+	cfgHealthChecksCloudflare     = false
+	cfgDatasetPaths               = false
+	cfgMaxScrapeDuration          = time.Duration(0)
+	cfgDisableHealthEndpoint      = false
+	cfgSince                      = ""
+	cfgRoutePrefix                = ""
+	cfgFetchZoneCounts            = false
+	cfgDatasetConcurrency         = 1
+	cfgPreregisterMetrics         = false
+	cfgEmitPeriodComparison       = false
+	cfgComparisonOffset           = 24 * time.Hour
+	cfgLabelTemplate              = ""
+	cfIncludeAccounts             = ""
+	// Cloudflare buckets become queryable some time after they close, so the
+	// query window ends this far in the past. Larger values trade freshness
+	// for fewer empty or partial buckets.
+	cfgClockSkewOffset = 2 * time.Minute
 )
 
 type cfResponseStreamingAnalytics struct {
@@ -34,76 +125,324 @@ type cfResponseStreamingAnalytics struct {
 }
 
 type cfResponseStreamingAnalyticsResp struct {
-	AccountStreamMinutesViewedAdaptiveGroupsSum []struct {
-		Sum struct {
-			MinutesViewed uint64 `json:"minutesViewed"`
-		} `json:"sum"`
-		Dimensions struct {
-			Ts time.Time `json:"ts"`
-		} `json:"dimensions"`
-	} `json:"streamMinutesViewedAdaptiveGroups"`
+	// Only requested by batched queries to tell the accounts apart.
+	AccountTag string `json:"accountTag,omitempty"`
+
+	AccountStreamMinutesViewedAdaptiveGroupsSum []cfStreamMinutesViewedGroup `json:"streamMinutesViewedAdaptiveGroups"`
+}
+
+type cfStreamMinutesViewedGroup struct {
+	Sum struct {
+		// Cloudflare returns null for some filter combinations, which must
+		// not be mistaken for zero minutes viewed.
+		MinutesViewed *jsonUint64 `json:"minutesViewed"`
+	} `json:"sum"`
+	Dimensions struct {
+		Ts   time.Time `json:"ts"`
+		Colo string    `json:"coloCode,omitempty"`
+	} `json:"dimensions"`
+}
+
+func (g cfStreamMinutesViewedGroup) minutes() uint64 {
+	if g.Sum.MinutesViewed == nil {
+		return 0
+	}
+	return uint64(*g.Sum.MinutesViewed)
+}
+
+// nonNullRows drops the rows with a null sum so they neither add to the
+// minutes viewed nor count as a bucket.
+func nonNullRows(rows []cfStreamMinutesViewedGroup) []cfStreamMinutesViewedGroup {
+	kept := make([]cfStreamMinutesViewedGroup, 0, len(rows))
+	for _, r := range rows {
+		if r.Sum.MinutesViewed == nil {
+			log.Debugf("Skipping bucket %s with null minutes viewed", r.Dimensions.Ts)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
 }
 
 var (
-	// Requests
-	cfStreamingMinutesViewed = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "cloudflare_streaming_minutes_viewed",
-		Help: "Number of minutes viewed by a user",
-	}, []string{"account"},
+	// Requests, registered by registerAccountMetrics once the unit is known
+	cfStreamingMinutesViewed *accountGaugeVec
+	cfBucketMinutesViewed    *accountBucketCollector
+	viewedMetricName         string
+	viewedUnitMultiplier     float64
+
+	cfScrapeIntervalSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_scrape_interval_seconds",
+		Help: "Configured interval between cloudflare scrapes",
+	})
+
+	cfExporterStartTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_exporter_start_time_seconds",
+		Help: "Start time of the exporter since unix epoch in seconds",
+	})
+
+	cfSlowScrapes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudflare_stream_slow_scrapes_total",
+		Help: "Number of scrape cycles that took longer than -scrape_slo",
+	})
+
+	cfSelfTest = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_self_test",
+		Help: "Always 1 once the exporter started, to check the scrape pipeline independently of cloudflare",
+	})
+
+	cfScrapeCycles = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudflare_stream_scrape_cycles_total",
+		Help: "Number of scrape cycles completed, whether or not the accounts could be fetched",
+	})
+
+	cfScrapePanics = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudflare_stream_scrape_panics_total",
+		Help: "Number of panics recovered while scraping cloudflare",
+	})
+)
+
+func registerAccountMetrics() error {
+	if err := registerViewedMetric(cfgViewedUnit); err != nil {
+		return err
+	}
+	registerFallbackMetric()
+	registerFailureMetric()
+	registerPermissionMetric()
+	registerRateMetric()
+	registerBucketsMetric()
+	registerWindowedMetrics()
+	if cfgGroupByColo {
+		registerDistinctColosMetric()
+	}
+	if cfgRequireAllAccountsHaveData {
+		registerAuditMetric()
+	}
+	if cfgHistoricalWindow > 0 {
+		registerHistoricalMetric()
+	}
+	if cfgTopVideos > 0 {
+		registerVideoMetric()
+	}
+	if cfgUniqueViewers {
+		registerUniqueViewersMetric()
+	}
+	if cfgFetchZoneCounts {
+		registerAccountInfoMetric()
+	}
+	if cfgEmitPeriodComparison {
+		registerDeltaMetric()
+	}
+	if cfgNativeHistograms {
+		registerNativeHistogramMetric()
+	}
+
+	return nil
+}
+
+func registerViewedMetric(unit string) error {
+	switch unit {
+	case "minutes":
+		viewedMetricName = "cloudflare_streaming_minutes_viewed"
+		viewedUnitMultiplier = 1
+	case "seconds":
+		viewedMetricName = "cloudflare_stream_seconds_viewed"
+		viewedUnitMultiplier = 60
+	default:
+		return fmt.Errorf("unsupported minutes viewed unit %q, expected minutes or seconds", unit)
+	}
+
+	help := "Number of " + unit + " viewed by a user"
+	if cfgUnifiedMetrics {
+		viewedMetricName = unifiedMetricName
+		cfStreamingMinutesViewed = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+			Name: unifiedMetricName,
+			Help: unifiedMetricHelp,
+		}, append(viewedLabelNames(), "dataset", "metric", "unit"),
+		)
+		return nil
+	}
+	if cfgUseBucketTimestamps {
+		cfBucketMinutesViewed = newAccountBucketCollector(viewedMetricName, help, viewedLabelNames())
+		return nil
+	}
+
+	cfStreamingMinutesViewed = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: viewedMetricName,
+		Help: help,
+	}, viewedLabelNames(),
 	)
+
+	return nil
+}
+
+// Cloudflare rejects larger per_page values on the accounts endpoint.
+const maxAccountsPageSize = 50
+
+const maxRESTRetryDelaySeconds = 30
+
+var errNoAPIToken = errors.New("no cloudflare api token configured")
+
+var (
+	apiClientsMu sync.Mutex
+	apiClients   = map[string]*cloudflare.API{}
 )
 
-func fetchAccounts() []cloudflare.Account {
-	var api *cloudflare.API
-	var err error
-	if len(cfgCfAPIToken) > 0 {
-		api, err = cloudflare.NewWithAPIToken(cfgCfAPIToken)
+// newAPIClient returns the rest client of the token, clients are reused so
+// the rate limit applies across calls.
+func newAPIClient(token apiToken) (*cloudflare.API, error) {
+	if len(token.value) == 0 {
+		return nil, errNoAPIToken
 	}
+
+	apiClientsMu.Lock()
+	defer apiClientsMu.Unlock()
+	if api, ok := apiClients[token.value]; ok {
+		return api, nil
+	}
+
+	api, err := cloudflare.NewWithAPIToken(token.value, apiClientOptions()...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	apiClients[token.value] = api
+
+	return api, nil
+}
+
+// apiClientOptions configures the rest client, which retries on its own
+// consistently with the graphql retry settings.
+func apiClientOptions() []cloudflare.Option {
+	minRetryDelay := int(cfgRetryBackoff.Seconds())
+	if minRetryDelay < 1 {
+		minRetryDelay = 1
 	}
 
-	ctx := context.Background()
-	a, _, err := api.Accounts(ctx, cloudflare.AccountsListParams{})
+	return []cloudflare.Option{
+		cloudflare.BaseURL(cfAPIEndpoint),
+		cloudflare.HTTPClient(apiClient),
+		// restLimit applies -cf_rate_limit in the transport instead.
+		cloudflare.UsingRateLimit(float64(rate.Inf)),
+		cloudflare.UsingRetryPolicy(cfgCfMaxRetries, minRetryDelay, maxRESTRetryDelaySeconds),
+	}
+}
+
+func fetchAccounts(ctx context.Context, token apiToken) ([]cloudflare.Account, error) {
+	ctx, span := tracer.Start(ctx, "fetchAccounts", trace.WithAttributes(attribute.String("token.name", token.name)))
+	defer span.End()
+
+	api, err := newAPIClient(token)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return a
+	var accounts []cloudflare.Account
+	for page := 1; ; page++ {
+		a, info, err := api.Accounts(ctx, cloudflare.AccountsListParams{
+			PaginationOptions: cloudflare.PaginationOptions{Page: page, PerPage: cfgAccountsPageSize},
+		})
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a...)
+
+		if page >= info.TotalPages || len(a) == 0 {
+			return accounts, nil
+		}
+	}
 }
 
-func fetchStreamingTotals(accountID string) (*cfResponseStreamingAnalytics, error) {
-	now := time.Now()
-	now30mAgo := now.Add(-30 * time.Minute)
+// buildStreamingQuery returns the minutes viewed query for a single account,
+// or with batch for all the accounts in $accountIDs at once.
+func buildStreamingQuery(batch bool) string {
+	dimensions := "ts: " + granularityDimensions[cfgGranularity]
+	if cfgGroupByColo {
+		dimensions += "\n\t\t\t\t\t\tcoloCode"
+	}
+
+	variables, filter, fields := "$accountID: String!", "accountTag: $accountID", ""
+	if batch {
+		variables, filter, fields = "$accountIDs: [String!]!", "accountTag_in: $accountIDs", "\n\t\t\t\taccountTag"
+	}
+
+	operation := "StreamMinutesViewed"
+	if batch {
+		operation = "StreamMinutesViewedBatch"
+	}
 
-	request := graphql.NewRequest(`
-	query ($accountID: String!, $mintime: Time!, $maxtime: Time!) {
+	return fmt.Sprintf(`
+	query %s(%s, $mintime: Time!, $maxtime: Time!) {
 		viewer {
-			accounts(filter: {accountTag: $accountID} ) {
-				streamMinutesViewedAdaptiveGroups(limit: 1000, orderBy: [sum_minutesViewed_DESC], filter: { datetime_geq: $mintime, datetime_lt: $maxtime}) {
+			accounts(filter: {%s} ) {%s
+				streamMinutesViewedAdaptiveGroups: %s(limit: %d, orderBy: [sum_%s_DESC], filter: { datetime_geq: $mintime, datetime_lt: $maxtime}) {
 					sum {
-						minutesViewed
+						minutesViewed: %s
 					}
 
 					dimensions {
-						ts: datetimeFiveMinutes
+						%s
 					}
 				}
 			}
 		}
 	}
-`)
-	if len(cfgCfAPIToken) > 0 {
-		request.Header.Set("Authorization", "Bearer "+cfgCfAPIToken)
+`, operationName(operation), variables, filter, fields, cfgStreamDataset, cfgGraphQLLimit, cfgStreamField, cfgStreamField, dimensions)
+}
+
+var graphqlNameRE = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// operationName prefixes a graphql operation name with
+// -graphql_operation_prefix, so several exporter instances can be told apart
+// in cloudflare's api analytics.
+func operationName(name string) string {
+	return cfgGraphQLOperationPrefix + name
+}
+
+func validateOperationPrefix(prefix string) error {
+	if len(prefix) > 0 && !graphqlNameRE.MatchString(prefix) {
+		return fmt.Errorf("invalid -graphql_operation_prefix %q, must be a graphql name", prefix)
 	}
-	request.Var("maxtime", now)
-	request.Var("mintime", now30mAgo)
-	request.Var("accountID", accountID)
+	return nil
+}
 
-	ctx := context.Background()
-	graphqlClient := graphql.NewClient(cfGraphQLEndpoint)
+// validateStreamDataset checks -stream_dataset and -stream_field can be put
+// in the query as is. They are aliased to the default names in the query, so
+// the response decodes the same whatever dataset is queried.
+func validateStreamDataset(dataset, field string) error {
+	if !graphqlNameRE.MatchString(dataset) {
+		return fmt.Errorf("invalid -stream_dataset %q, must be a graphql name", dataset)
+	}
+	if !graphqlNameRE.MatchString(field) {
+		return fmt.Errorf("invalid -stream_field %q, must be a graphql name", field)
+	}
+	return nil
+}
+
+// queryWindow returns the bounds of a window of the given length ending now,
+// shifted back by -clock_skew_offset.
+func queryWindow(window time.Duration) (time.Time, time.Time) {
+	end := time.Now().Add(-cfgClockSkewOffset)
+	return end.Add(-window), end
+}
+
+func fetchStreamingTotals(ctx context.Context, account monitoredAccount, mintime, maxtime time.Time) (*cfResponseStreamingAnalytics, error) {
+	ctx, span := tracer.Start(ctx, "fetchStreamingTotals", trace.WithAttributes(attribute.String("account.id", account.ID)))
+	defer span.End()
+
+	ctx = withDebugAccount(ctx, account.ID)
+	request := graphql.NewRequest(buildStreamingQuery(false))
+	if len(account.token.value) > 0 {
+		request.Header.Set("Authorization", "Bearer "+account.token.value)
+	}
+	request.Var("maxtime", maxtime)
+	request.Var("mintime", mintime)
+	request.Var("accountID", account.ID)
+
+	graphqlClient := graphql.NewClient(cfGraphQLEndpoint, graphql.WithHTTPClient(apiClient))
 	var resp cfResponseStreamingAnalytics
 	if err := graphqlClient.Run(ctx, request, &resp); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		log.Error(err)
 		return nil, err
 	}
@@ -111,21 +450,175 @@ func fetchStreamingTotals(accountID string) (*cfResponseStreamingAnalytics, erro
 	return &resp, nil
 }
 
-func fetchStreamingAnalytics(account cloudflare.Account) {
-	r, err := fetchStreamingTotals(account.ID)
+// roundValue rounds v to -value_precision decimal places, a negative
+// precision leaves it untouched.
+func roundValue(v float64) float64 {
+	if cfgValuePrecision < 0 {
+		return v
+	}
+	scale := math.Pow(10, float64(cfgValuePrecision))
+	return math.Round(v*scale) / scale
+}
+
+func totalMinutes(rows []cfStreamMinutesViewedGroup) uint64 {
+	var total uint64
+	for _, r := range rows {
+		total += r.minutes()
+	}
+	return total
+}
+
+// deleteMinutesViewed removes the viewed series of the account, for every
+// colo when grouping by colo.
+func deleteMinutesViewed(account monitoredAccount) {
+	if cfBucketMinutesViewed != nil {
+		cfBucketMinutesViewed.deletePartialMatch(account, accountLabels(account))
+		return
+	}
+	cfStreamingMinutesViewed.deletePartialMatch(account, accountLabels(account))
+}
+
+func setMinutesViewed(account monitoredAccount, minutes float64) {
+	setMinutesViewedAt(account, viewedLabels(account, ""), minutes, time.Time{})
+}
+
+// setMinutesViewedAt stamps the sample with ts when -use_bucket_timestamps is
+// set, otherwise ts is ignored and the value is exposed at scrape time.
+func setMinutesViewedAt(account monitoredAccount, labels prometheus.Labels, minutes float64, ts time.Time) {
+	value := roundValue(minutes * viewedUnitMultiplier)
+	if cfgUnifiedMetrics {
+		labels = usageLabels(labels, cfgStreamDataset, usageMetricViewed, cfgViewedUnit)
+	}
+	if cfBucketMinutesViewed != nil {
+		cfBucketMinutesViewed.set(account, labels, value, ts)
+		return
+	}
+	cfStreamingMinutesViewed.set(account, labels, value)
+}
+
+// fetchStreamingAnalytics updates every dataset of the account on its own, a
+// failing query only leaves its own metrics stale.
+func fetchStreamingAnalytics(ctx context.Context, account monitoredAccount) {
+	start, end := scrapeWindow()
+	fetchMinutesViewed(ctx, account, start, end)
+	fetchAccountDatasets(ctx, account, start, end)
+}
+
+// fetchAccountDatasets queries the optional datasets beside minutes viewed,
+// up to -dataset_concurrency at a time. Each dataset updates its own metrics
+// only, so their order does not matter.
+func fetchAccountDatasets(ctx context.Context, account monitoredAccount, start, end time.Time) {
+	var fetches []func()
+	if cfgTopVideos > 0 {
+		fetches = append(fetches, func() { fetchTopVideos(ctx, account, start, end) })
+	}
+	if cfgUniqueViewers {
+		fetches = append(fetches, func() { fetchUniqueViewers(ctx, account, start, end) })
+	}
+	if cfgEmitPeriodComparison {
+		fetches = append(fetches, func() { fetchPeriodComparison(ctx, account, start, end) })
+	}
+	if cfgFetchZoneCounts {
+		fetches = append(fetches, func() { fetchZoneCount(ctx, account) })
+	}
+
+	pool := newWorkerPool(cfgDatasetConcurrency)
+	for _, fetch := range fetches {
+		pool.run(fetch)
+	}
+	pool.wait()
+}
+
+func fetchMinutesViewed(ctx context.Context, account monitoredAccount, start, end time.Time) {
+	r, err := fetchStreamingTotals(ctx, account, start, end)
+	recordFetchResult(account, err)
 	if err != nil {
 		log.Error(err)
+		if cfgEnableRESTFallback {
+			fetchStreamingAnalyticsREST(ctx, account, start, end)
+		}
 		return
 	}
+	if cfgEnableRESTFallback {
+		cfStreamUsingFallback.set(account, accountLabels(account), 0)
+	}
 
 	for _, a := range r.Viewer.Accounts {
+		processStreamingAnalytics(account, a, start, end)
+	}
+}
+
+func processStreamingAnalytics(account monitoredAccount, a cfResponseStreamingAnalyticsResp, start, end time.Time) {
+	rows := nonNullRows(a.AccountStreamMinutesViewedAdaptiveGroupsSum)
+	buckets := distinctBuckets(rows)
+	cfBucketsReturned.set(account, accountLabels(account), float64(buckets))
+	if cfgGroupByColo {
+		cfDistinctColos.set(account, accountLabels(account), float64(distinctColos(rows)))
+	}
+	cfMinutesViewedPerMinute.set(account, accountLabels(account), roundValue(minutesViewedPerMinute(rows, start, end)))
+
+	if len(rows) > 0 && cfgRequireAllAccountsHaveData {
+		firstScrapeAudit.markData(account)
+	}
+	total := totalMinutes(rows)
+	cycleSummary.addMinutes(total)
+	recordWindowTotal(account, total)
+	if float64(total) < cfgMinMinutesViewed {
+		log.Debugf("Not exporting %s, %d minutes viewed is below -min_minutes_viewed", account.Name, total)
+		deleteMinutesViewed(account)
+		deleteWindowedMinutes(account)
+		deleteBucketMinutes(account)
+		return
+	}
+	setWindowedMinutes(account, rows, end)
+	observeBucketMinutes(account, rows, start, end)
+	dropPlaceholder(account)
+
+	groups := groupRowsByColo(rows, cfgMaxColos)
+	if cfgGroupByColo {
+		deleteStaleColos(account, groups)
+	}
+	for colo, coloRows := range groups {
+		labels := viewedLabels(account, colo)
+		if cfgUseBucketTimestamps {
+			if ts, minutes, ok := latestCompleteBucket(coloRows, end); ok {
+				setMinutesViewedAt(account, labels, float64(minutes), ts)
+			}
+			continue
+		}
+
 		sum := 0
 
-		for _, b := range a.AccountStreamMinutesViewedAdaptiveGroupsSum {
-			sum += int(b.Sum.MinutesViewed)
+		for _, b := range coloRows {
+			sum += int(b.minutes())
 		}
 
-		cfStreamingMinutesViewed.With(prometheus.Labels{"account": account.Name}).Set(float64(sum) / float64(len(a.AccountStreamMinutesViewedAdaptiveGroupsSum)))
+		// Average per -granularity bucket, no buckets means nothing was viewed.
+		avg := 0.0
+		if buckets > 0 {
+			avg = float64(sum) / float64(buckets)
+		}
+		setMinutesViewedAt(account, labels, avg, time.Time{})
+	}
+}
+
+func validateSortAccounts(by string) error {
+	switch by {
+	case "", "id", "name":
+		return nil
+	default:
+		return fmt.Errorf("unsupported -sort_accounts %q, expected id or name", by)
+	}
+}
+
+// sortAccounts orders the accounts by -sort_accounts so they are fetched and
+// logged in the same order on every run, an empty value keeps cloudflare's.
+func sortAccounts(accounts []monitoredAccount, by string) {
+	switch by {
+	case "id":
+		sort.SliceStable(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	case "name":
+		sort.SliceStable(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
 	}
 }
 
@@ -138,50 +631,465 @@ func contains(s []string, e string) bool {
 	return false
 }
 
-func fetchMetrics() {
-	accounts := fetchAccounts()
+func monitoredAccounts(ctx context.Context) ([]monitoredAccount, error) {
+	accounts, err := fetchAllAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	monitored, _, err := filterAccounts(accounts)
+	markDuplicateNames(monitored)
+	return monitored, err
+}
 
-	accountsToHandle := strings.Split(cfIncludeAccounts, ",")
+// fetchMetrics runs a scrape cycle, accounts not started before ctx is done
+// are skipped and the running queries are cancelled with it.
+func fetchMetrics(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "fetchMetrics")
+	defer span.End()
 
+	if cfgMaxScrapeDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfgMaxScrapeDuration)
+		defer cancel()
+	}
+
+	start := time.Now()
+	cycleSummary.reset()
+	defer func() {
+		recordScrapeDuration(time.Since(start))
+		cycleSummary.updateSuccessRatio()
+		cycleSummary.log(time.Since(start))
+		cfScrapeCycles.Inc()
+	}()
+
+	seriesLimit.beginScrape()
+
+	if len(cfgIncludeAccountsURL) > 0 {
+		if err := remoteIncludes.refresh(ctx, cfgIncludeAccountsURL); err != nil {
+			log.Errorf("Refreshing -include_accounts_url, keeping the last list: %s", err)
+		}
+	}
+
+	all, err := fetchAllAccounts(ctx)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	accounts, skipped, err := filterAccounts(all)
+	for reason, n := range skipped {
+		cfAccountsSkipped.WithLabelValues(reason).Add(float64(n))
+	}
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	sortAccounts(accounts, cfgSortAccounts)
+	markDuplicateNames(accounts)
+	cycleSummary.addAccounts(len(accounts))
+	lastAccounts.set(accounts)
+
+	if cfgBatchAccounts {
+		fetchStreamingAnalyticsBatched(ctx, accounts)
+		return
+	}
+
+	// Each account gets its own deadline so a hanging query only costs that
+	// account its update while the other workers keep going.
+	pool := newWorkerPool(cfgConcurrency)
 	for _, a := range accounts {
-		if len(accountsToHandle) > 0 {
-			if !contains(accountsToHandle, a.ID) {
-				continue
+		a := a
+		pool.run(func() {
+			if ctx.Err() != nil {
+				skipPastDeadline(a)
+				return
 			}
-		}
+			accountCtx, cancel := context.WithTimeout(ctx, cfgRequestTimeout)
+			defer cancel()
+
+			log.Debugf("Fetching streaming analytics for %s", a.Name)
+			fetchStreamingAnalytics(accountCtx, a)
+		})
+	}
+	pool.wait()
+}
+
+// selfTest requests the metrics path from the http handlers in process, to
+// confirm the registry and handler work before any data is fetched.
+func selfTest(path string) error {
+	rec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("%s returned %d", path, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "cloudflare_stream_self_test") {
+		return fmt.Errorf("%s does not serve cloudflare_stream_self_test", path)
+	}
+	return nil
+}
+
+// skipPastDeadline accounts for an account left out because the cycle ran
+// past -max_scrape_duration or was cancelled by the shutdown.
+func skipPastDeadline(a monitoredAccount) {
+	log.Warnf("Skipping %s, the scrape ran past -max_scrape_duration %s or is shutting down", a.Name, cfgMaxScrapeDuration)
+	cfAccountsSkipped.WithLabelValues(skipReasonDeadline).Inc()
+	recordFetchResult(a, context.DeadlineExceeded)
+}
+
+// recoverScrapePanic logs and counts a panic raised while scraping so a bug
+// in one cycle or account does not take the whole exporter down. It must be
+// deferred directly by the goroutine doing the work.
+func recoverScrapePanic() {
+	if r := recover(); r != nil {
+		log.Errorf("Recovered from panic while scraping: %v", r)
+		cfScrapePanics.Inc()
+	}
+}
+
+// recordScrapeDuration counts the cycle as slow when it exceeded -scrape_slo,
+// which defaults to the scrape interval.
+func recordScrapeDuration(d time.Duration) {
+	slo := cfgScrapeSLO
+	if slo <= 0 {
+		slo = cfgScrapeInterval
+	}
+	if d > slo {
+		log.Warnf("Scrape took %s, exceeding the %s slo", d, slo)
+		cfSlowScrapes.Inc()
+	}
+}
+
+// alignDelay returns how long to wait from now until the next wall-clock
+// multiple of interval, e.g. the top of the minute for a 60s interval.
+func alignDelay(now time.Time, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
 
-		log.Printf("Fetching streaming analytics for %s", a.Name)
-		fetchStreamingAnalytics(a)
+	next := now.Truncate(interval)
+	if next.Equal(now) {
+		return 0
 	}
+
+	return next.Add(interval).Sub(now)
+}
+
+func newListener(network, addr string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported listen network %q, expected tcp, tcp4 or tcp6", network)
+	}
+
+	return net.Listen(network, addr)
+}
+
+// exportStartupMetrics sets the gauges that stay constant for the life of the
+// process, once the flags are parsed.
+func exportStartupMetrics() {
+	cfScrapeIntervalSeconds.Set(cfgScrapeInterval.Seconds())
+	cfExporterStartTime.SetToCurrentTime()
+	cfSelfTest.Set(1)
+	cfQueryWindowSeconds.Set(cfgLookback.Seconds())
+	cfQueryGranularitySeconds.Set(cfgGranularity.Seconds())
 }
 
 func main() {
-	flag.StringVar(&cfgListen, "listen", cfgListen, "listen on addr:port ( default :8080), omit addr to listen on all interfaces")
-	flag.StringVar(&cfgCfAPIToken, "cf_api_token", cfgCfAPIToken, "cloudflare api token (preferred)")
+	flag.StringVar(&cfgListen, "listen", cfgListen, "listen on addr:port ( default :8080), omit addr to listen on all interfaces, use [addr]:port for IPv6 literals")
+	flag.StringVar(&cfgListenNetwork, "listen_network", cfgListenNetwork, "network to listen on: tcp (dual-stack), tcp4 or tcp6")
+	flag.StringVar(&cfgMetricsPath, "metrics_path", cfgMetricsPath, "comma-separated paths under which to expose metrics")
+	flag.StringVar(&cfgCfAPIToken, "cf_api_token", cfgCfAPIToken, "cloudflare api token (preferred), comma-separated to monitor accounts from several tokens")
+	flag.StringVar(&cfgCfAPITokenNames, "cf_api_token_names", cfgCfAPITokenNames, "comma-separated names for the tokens in -cf_api_token, used as the token_name label")
+	flag.StringVar(&cfGraphQLEndpoint, "cf_graphql_endpoint", cfGraphQLEndpoint, "cloudflare graphql endpoint, comma-separated to spread requests over several and fail over between them")
+	flag.StringVar(&cfAPIEndpoint, "cf_api_endpoint", cfAPIEndpoint, "cloudflare rest api base url")
 	flag.StringVar(&cfIncludeAccounts, "include_accounts", cfIncludeAccounts, "comma-separated list of accounts to include")
+	flag.StringVar(&cfgAccountIDs, "account_id", cfgAccountIDs, "comma-separated account IDs to monitor instead of listing the accounts of the token")
+	flag.StringVar(&cfgIncludeAccountsContains, "include_accounts_contains", cfgIncludeAccountsContains, "also include accounts whose name contains this substring (case-insensitive)")
+	flag.StringVar(&cfgExcludeAccounts, "exclude_accounts", cfgExcludeAccounts, "comma-separated list of accounts to exclude")
+	flag.DurationVar(&cfgClockSkewOffset, "clock_skew_offset", cfgClockSkewOffset, "shift the query window this far into the past to avoid requesting buckets cloudflare has not published yet (higher = less fresh)")
+	flag.DurationVar(&cfgScrapeInterval, "scrape_interval", cfgScrapeInterval, "interval between cloudflare scrapes")
+	flag.IntVar(&cfgMaxSeries, "max_series", cfgMaxSeries, "maximum number of distinct series to emit, 0 disables the limit")
+	flag.StringVar(&cfgOtelEndpoint, "otel_endpoint", cfgOtelEndpoint, "otlp/http collector url (e.g. http://localhost:4318) to export traces to, tracing is disabled when empty")
+	flag.StringVar(&cfgViewedUnit, "minutes_viewed_unit", cfgViewedUnit, "unit of the viewed metric: minutes or seconds (exported as cloudflare_stream_seconds_viewed)")
+	flag.BoolVar(&cfgSmokeTest, "smoke_test", cfgSmokeTest, "fetch accounts and one streaming analytics query against cloudflare, then exit")
+	flag.BoolVar(&cfgAlignToInterval, "align_to_interval", cfgAlignToInterval, "delay the first scrape until the next wall-clock multiple of -scrape_interval")
+	flag.BoolVar(&cfgEnableRESTFallback, "enable_rest_fallback", cfgEnableRESTFallback, "fall back to the rest stream analytics api when the graphql query fails")
+	flag.BoolVar(&cfgUseBucketTimestamps, "use_bucket_timestamps", cfgUseBucketTimestamps, "expose the latest complete bucket stamped with its bucket time instead of the window average")
+	flag.IntVar(&cfgConcurrency, "concurrency", cfgConcurrency, "number of accounts fetched in parallel")
+	flag.DurationVar(&cfgRequestTimeout, "request_timeout", cfgRequestTimeout, "timeout for fetching the analytics of a single account")
+	flag.BoolVar(&cfgGroupByColo, "group_by_colo", cfgGroupByColo, "add a colo label with the cloudflare data center serving the views")
+	flag.IntVar(&cfgMaxColos, "max_colos", cfgMaxColos, "with -group_by_colo, keep the colos with the most minutes viewed and aggregate the rest as colo=\"other\", 0 disables the cap")
+	flag.BoolVar(&cfgOneshot, "oneshot", cfgOneshot, "collect the metrics once, print them to stdout and exit")
+	flag.DurationVar(&cfgHistoricalWindow, "historical_window", cfgHistoricalWindow, "with -oneshot, also export daily minutes viewed over this lookback (e.g. 720h)")
+	flag.BoolVar(&cfgStrictFilters, "strict_filters", cfgStrictFilters, "exit at startup when the account filters leave no account to monitor")
+	flag.StringVar(&cfgOutputFormat, "output_format", cfgOutputFormat, "with -oneshot, format written to stdout: prometheus, influx (line protocol) or json")
+	flag.StringVar(&cfgConstLabels, "const_labels", cfgConstLabels, "comma-separated key=value labels added to every exported metric, e.g. region=eu,cluster=prod")
+	flag.StringVar(&cfgPushgatewayURL, "pushgateway_url", cfgPushgatewayURL, "push the metrics to this pushgateway after every scrape and once more on shutdown")
+	flag.StringVar(&cfgPushgatewayJob, "pushgateway_job", cfgPushgatewayJob, "job name used when pushing to the pushgateway")
+	flag.DurationVar(&cfgShutdownTimeout, "shutdown_timeout", cfgShutdownTimeout, "time allowed for the final scrape and for draining http connections on shutdown")
+	flag.IntVar(&cfgAccountsPageSize, "accounts_page_size", cfgAccountsPageSize, "number of accounts requested per page when listing accounts (cloudflare allows at most 50)")
+	flag.IntVar(&cfgMaxRetries, "max_retries", cfgMaxRetries, "number of times a failed graphql request is retried")
+	flag.DurationVar(&cfgRetryBackoff, "retry_backoff", cfgRetryBackoff, "initial delay between graphql retries, doubled on each attempt up to 30s and jittered unless cloudflare sends Retry-After")
+	flag.DurationVar(&cfgMaxRetryDuration, "max_retry_duration", cfgMaxRetryDuration, "total time a graphql request may spend retrying, 0 for no limit besides -max_retries")
+	flag.Float64Var(&cfgCfRateLimit, "cf_rate_limit", cfgCfRateLimit, "maximum requests per second of the cloudflare rest client")
+	flag.IntVar(&cfgCfMaxRetries, "cf_max_retries", cfgCfMaxRetries, "number of times a failed cloudflare rest request is retried")
+	flag.BoolVar(&cfgBatchAccounts, "batch_accounts", cfgBatchAccounts, "query the analytics of up to 25 accounts sharing a token in a single graphql request")
+	flag.DurationVar(&cfgScrapeSLO, "scrape_slo", cfgScrapeSLO, "scrape cycles slower than this count as slow, defaults to -scrape_interval")
+	flag.StringVar(&cfgLabelBy, "label_by", cfgLabelBy, "identify accounts on metrics by id (account_id label), name (account label) or both")
+	flag.StringVar(&cfgGraphQLOperationPrefix, "graphql_operation_prefix", cfgGraphQLOperationPrefix, "prefix for the graphql operation names sent to cloudflare, to distinguish exporter instances")
+	flag.IntVar(&cfgTopVideos, "top_videos", cfgTopVideos, "export the minutes viewed of the N most watched videos of each account, 0 disables")
+	flag.StringVar(&cfgScrapeSchedule, "scrape_schedule", cfgScrapeSchedule, "only scrape within this local time window, as [Mon-Fri ]HH:MM-HH:MM")
+	flag.DurationVar(&cfgLookback, "lookback", cfgLookback, "length of the window queried on each scrape")
+	flag.DurationVar(&cfgGranularity, "granularity", cfgGranularity, "size of the buckets the query window is split in, one of 1m, 5m, 15m or 1h")
+	flag.StringVar(&cfgConfigSource, "config_source", cfgConfigSource, "load settings from an external store at startup, taking precedence over flags (consul)")
+	flag.StringVar(&cfgConsulAddr, "consul_addr", cfgConsulAddr, "address of the consul agent used by -config_source=consul")
+	flag.StringVar(&cfgConsulKey, "consul_key", cfgConsulKey, "consul kv key holding the settings, one \"name value\" per line")
+	flag.StringVar(&cfgMetricsFile, "metrics_file", cfgMetricsFile, "write the metrics to this file after every scrape, for the node_exporter textfile collector")
+	flag.BoolVar(&cfgEnableDebugEndpoints, "enable_debug_endpoints", cfgEnableDebugEndpoints, "serve /debug/last_response with the last raw graphql response per account, /config with the effective settings, secrets redacted, and /query for ad hoc windows")
+	flag.IntVar(&cfgValuePrecision, "value_precision", cfgValuePrecision, "round the exported minutes viewed to this many decimal places, negative disables rounding")
+	flag.BoolVar(&cfgUniqueViewers, "unique_viewers", cfgUniqueViewers, "export the unique viewers of each account over the query window, costs one more query per account")
+	flag.StringVar(&cfgCAFile, "ca_file", cfgCAFile, "PEM bundle of extra certificate authorities to trust for the cloudflare api")
+	flag.IntVar(&cfgGraphQLLimit, "graphql_limit", cfgGraphQLLimit, "maximum number of rows requested per account from the graphql api")
+	flag.StringVar(&cfgIncludeAccountsURL, "include_accounts_url", cfgIncludeAccountsURL, "url of a newline or comma separated list of account IDs to include, refreshed every scrape, an empty list includes no account")
+	flag.StringVar(&cfgUnknownAccountName, "unknown_account_name", cfgUnknownAccountName, "account label used when the name of an account cannot be resolved, {id} is replaced by its ID")
+	flag.Float64Var(&cfgMinMinutesViewed, "min_minutes_viewed", cfgMinMinutesViewed, "do not export the minutes viewed of accounts with fewer minutes viewed over the query window")
+	flag.StringVar(&cfgRemoteWriteURL, "remote_write_url", cfgRemoteWriteURL, "prometheus remote write endpoint receiving the metrics after every scrape")
+	flag.StringVar(&cfgSortAccounts, "sort_accounts", cfgSortAccounts, "fetch accounts in a deterministic order, by id or name")
+	flag.Int64Var(&cfgMaxResponseBytes, "max_response_bytes", cfgMaxResponseBytes, "largest graphql response body accepted, larger responses fail the query")
+	flag.StringVar(&cfgConfigFile, "config_file", cfgConfigFile, "flag file with the base settings, one \"name value\" per line")
+	flag.StringVar(&cfgConfigFileOverride, "config_file_override", cfgConfigFileOverride, "flag file whose settings override -config_file, e.g. per environment")
+	flag.StringVar(&cfgAccountAliasFile, "account_alias_file", cfgAccountAliasFile, "yaml file mapping account IDs to the name used in the account label")
+	flag.StringVar(&cfgStreamDataset, "stream_dataset", cfgStreamDataset, "graphql dataset queried for the minutes viewed")
+	flag.StringVar(&cfgStreamField, "stream_field", cfgStreamField, "summed field of -stream_dataset exported as minutes viewed")
+	flag.StringVar(&cfgHistogramBuckets, "histogram_buckets", cfgHistogramBuckets, "comma-separated upper bounds in seconds of the latency histogram buckets, exponential from 50ms by default")
+	flag.BoolVar(&cfgNativeHistograms, "native_histograms", cfgNativeHistograms, "export the minutes viewed per -granularity bucket of the window as the native histogram cloudflare_stream_bucket_minutes_viewed, whose buckets are only served over protobuf")
+	flag.BoolVar(&cfgRequireAllAccountsHaveData, "require_all_accounts_have_data", cfgRequireAllAccountsHaveData, "after the first full scrape, report the monitored accounts that returned no stream data")
+	flag.BoolVar(&cfgUnifiedMetrics, "unified_metrics", cfgUnifiedMetrics, "export minutes viewed and unique viewers on a single cloudflare_stream_usage gauge with dataset, metric and unit labels")
+	flag.BoolVar(&cfgHealthChecksCloudflare, "health_checks_cloudflare", cfgHealthChecksCloudflare, "check the cloudflare api is reachable from /health, cached for 10s")
+	flag.BoolVar(&cfgDatasetPaths, "dataset_paths", cfgDatasetPaths, "also serve the metrics of each dataset alone at <metrics_path>/viewed, /videos and /uniques")
+	flag.DurationVar(&cfgMaxScrapeDuration, "max_scrape_duration", cfgMaxScrapeDuration, "deadline of a whole scrape cycle, accounts not fetched by then are skipped, 0 disables")
+	flag.BoolVar(&cfgDisableHealthEndpoint, "disable_health_endpoint", cfgDisableHealthEndpoint, "do not serve /health, e.g. when health is checked by a sidecar")
+	flag.StringVar(&cfgSince, "since", cfgSince, "with -oneshot, query from this RFC3339 time instead of -lookback before now")
+	flag.StringVar(&cfgRoutePrefix, "route_prefix", cfgRoutePrefix, "path prefix of every route, e.g. /exporter when served at a subpath by a reverse proxy")
+	flag.BoolVar(&cfgFetchZoneCounts, "fetch_zone_counts", cfgFetchZoneCounts, "export cloudflare_stream_account_info with the number of zones of each account, one extra rest call per account and scrape")
+	flag.IntVar(&cfgDatasetConcurrency, "dataset_concurrency", cfgDatasetConcurrency, "number of datasets of one account fetched in parallel, on top of -concurrency")
+	flag.BoolVar(&cfgPreregisterMetrics, "preregister_metrics", cfgPreregisterMetrics, "expose a zero minutes viewed series for every account at startup, before the first scrape")
+	flag.BoolVar(&cfgEmitPeriodComparison, "emit_period_comparison", cfgEmitPeriodComparison, "export cloudflare_stream_minutes_viewed_delta against the window -comparison_offset earlier, one extra graphql query per account")
+	flag.DurationVar(&cfgComparisonOffset, "comparison_offset", cfgComparisonOffset, "how far back the window of -emit_period_comparison is")
+	flag.StringVar(&cfgLabelTemplate, "label_template", cfgLabelTemplate, "go text/template composing the account label from .ID, .Name and .Type, e.g. {{.Name}}-{{.Type}}; aliases still win")
 	flag.Parse()
+	if err := applyConfigFiles(cfgConfigFile, cfgConfigFileOverride); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfgConfigSource) > 0 {
+		source, err := newConfigSource(cfgConfigSource)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := applyConfigSource(context.Background(), source); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if !(len(cfgCfAPIToken) > 0) {
 		log.Fatal("Please provide CF_API_KEY+CF_API_EMAIL or CF_API_TOKEN")
 	}
+	if cfgScrapeInterval <= 0 {
+		log.Fatal("-scrape_interval must be positive")
+	}
 	customFormatter := new(log.TextFormatter)
 	customFormatter.TimestampFormat = "2006-01-02 15:04:05"
 	log.SetFormatter(customFormatter)
 	customFormatter.FullTimestamp = true
 
+	var err error
+	apiTokens, err = parseAPITokens(cfgCfAPIToken, cfgCfAPITokenNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(cfgCAFile) > 0 {
+		if err := useCAFile(cfgCAFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cfgCfRateLimit <= 0 {
+		log.Fatal("-cf_rate_limit must be positive")
+	}
+	if cfgCfMaxRetries < 0 {
+		log.Fatal("-cf_max_retries must not be negative")
+	}
+
+	graphqlEndpoints, err = parseGraphQLEndpoints(cfGraphQLEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfGraphQLEndpoint = graphqlEndpoints[0].String()
+	if err := validateQueryWindow(cfgLookback, cfgGranularity); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfgSince) > 0 {
+		if !cfgOneshot {
+			log.Fatal("-since requires -oneshot")
+		}
+		sinceTime, err = parseSince(cfgSince, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	cfgRoutePrefix, err = normalizeRoutePrefix(cfgRoutePrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	buckets, err := parseHistogramBuckets(cfgHistogramBuckets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	registerAPIMetrics(buckets)
+	if err := validateOperationPrefix(cfgGraphQLOperationPrefix); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateStreamDataset(cfgStreamDataset, cfgStreamField); err != nil {
+		log.Fatal(err)
+	}
+	if cfgGraphQLLimit < 1 || cfgGraphQLLimit > maxGraphQLLimit {
+		log.Fatalf("-graphql_limit must be between 1 and %d", maxGraphQLLimit)
+	}
+
+	if len(cfgIncludeAccountsURL) > 0 {
+		if err := remoteIncludes.refresh(context.Background(), cfgIncludeAccountsURL); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfgSmokeTest {
+		if err := runSmokeTest(context.Background()); err != nil {
+			log.Fatal("Smoke test failed: ", err)
+		}
+		log.Info("Smoke test passed")
+		return
+	}
+
+	if err := validateLabelBy(cfgLabelBy); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSortAccounts(cfgSortAccounts); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfgAccountAliasFile) > 0 {
+		aliases, err := loadAccountAliases(cfgAccountAliasFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		accountAliases = aliases
+	}
+	if len(cfgLabelTemplate) > 0 {
+		tmpl, err := parseLabelTemplate(cfgLabelTemplate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		labelTemplate = tmpl
+	}
+	if len(cfgScrapeSchedule) > 0 {
+		schedule, err := parseScrapeSchedule(cfgScrapeSchedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		activeSchedule = schedule
+	}
+	if cfgTopVideos < 0 {
+		log.Fatal("-top_videos must not be negative")
+	}
+	if cfgUseBucketTimestamps && cfgUnifiedMetrics {
+		log.Fatal("-unified_metrics cannot be combined with -use_bucket_timestamps")
+	}
+	if cfgUseBucketTimestamps && len(cfgMetricsFile) > 0 {
+		log.Fatal("-metrics_file cannot be combined with -use_bucket_timestamps, the textfile collector rejects samples with timestamps")
+	}
+	if cfgUseBucketTimestamps {
+		log.Warn("Bucket timestamps are lagging by design, prometheus drops samples older than its out-of-order window and marks series stale after 5m without new samples")
+	}
+	if err := registerAccountMetrics(); err != nil {
+		log.Fatal(err)
+	}
+	exportStartupMetrics()
+
+	constLabels, err := parseConstLabels(cfgConstLabels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gatherer = newConstLabelGatherer(allGatherers(), constLabels)
+	seriesLimit.max = cfgMaxSeries
+	if cfgAccountsPageSize < 1 || cfgAccountsPageSize > maxAccountsPageSize {
+		log.Fatalf("-accounts_page_size must be between 1 and %d", maxAccountsPageSize)
+	}
+	if cfgConcurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+	if cfgEmitPeriodComparison && (cfgComparisonOffset <= 0 || cfgComparisonOffset+cfgLookback > maxQueryWindow) {
+		log.Fatalf("-comparison_offset must be positive and together with -lookback within the %s cloudflare retains", maxQueryWindow)
+	}
+	if cfgDatasetConcurrency < 1 {
+		log.Fatal("-dataset_concurrency must be at least 1")
+	}
+
+	warnCancelingFilters()
+	checkTokenPermissions(context.Background())
+	checkFilters(context.Background())
+
+	if cfgHistoricalWindow > 0 && !cfgOneshot {
+		log.Fatal("-historical_window requires -oneshot")
+	}
+	if err := validateOutputFormat(cfgOutputFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(cfgOtelEndpoint) > 0 {
+		shutdown, err := setupTracing(context.Background(), cfgOtelEndpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer shutdown(context.Background())
+	}
+
+	if cfgOneshot {
+		if err := runOneshot(context.Background(), os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if cfgPreregisterMetrics {
+		preregisterMetrics(context.Background())
+	}
 	go func() {
-		for ; true; <-time.NewTicker(60 * time.Second).C {
-			fetchMetrics()
+		if cfgAlignToInterval {
+			delay := alignDelay(time.Now(), cfgScrapeInterval)
+			log.Infof("Delaying first scrape by %s to align to %s boundaries", delay, cfgScrapeInterval)
+			time.Sleep(delay)
+		}
+
+		ticker := time.NewTicker(cfgScrapeInterval)
+		for ; true; <-ticker.C {
+			scrapeAndPush(context.Background())
 		}
 	}()
 
 	//This section will start the HTTP server and expose
 	//any metrics on the /metrics endpoint.
-	if !strings.HasPrefix(cfgMetricsPath, "/") {
-		cfgMetricsPath = "/" + cfgMetricsPath
-	}
-	http.Handle(cfgMetricsPath, promhttp.Handler())
-	h := health.New(health.Health{})
-	http.HandleFunc("/health", h.Handler)
-	log.Info("Beginning to serve on port", cfgListen, ", metrics path ", cfgMetricsPath)
-	log.Fatal(http.ListenAndServe(cfgListen, nil))
+	metricsPaths, err := parseMetricsPaths(cfgMetricsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	registerRoutes(metricsPaths, constLabels)
+	server, err := serve(cfgListenNetwork, cfgListen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Info("Beginning to serve on port", cfgListen, " (", cfgListenNetwork, "), metrics paths ", strings.Join(metricsPaths, ", "))
+	if err := selfTest(metricsPaths[0]); err != nil {
+		log.Errorf("Self test failed: %s", err)
+	} else {
+		log.Infof("Self test passed, %s serves cloudflare_stream_self_test", metricsPaths[0])
+	}
+
+	waitForShutdown(server, cfgShutdownTimeout)
 }
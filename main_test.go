@@ -0,0 +1,752 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestNewListener(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %s", err)
+	} else {
+		l.Close()
+	}
+
+	tests := []struct {
+		network, addr string
+		wantIPv6      bool
+		wantErr       bool
+	}{
+		{network: "tcp6", addr: "[::1]:0", wantIPv6: true},
+		{network: "tcp6", addr: ":0", wantIPv6: true},
+		{network: "tcp4", addr: "127.0.0.1:0"},
+		{network: "tcp", addr: "[::1]:0", wantIPv6: true},
+		{network: "tcp4", addr: "[::1]:0", wantErr: true},
+		{network: "tcp6", addr: "127.0.0.1:0", wantErr: true},
+		{network: "udp", addr: ":0", wantErr: true},
+	}
+	for _, tt := range tests {
+		l, err := newListener(tt.network, tt.addr)
+		if tt.wantErr {
+			if err == nil {
+				l.Close()
+				t.Errorf("newListener(%q, %q) succeeded, want an error", tt.network, tt.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newListener(%q, %q): %s", tt.network, tt.addr, err)
+			continue
+		}
+		addr := l.Addr().(*net.TCPAddr)
+		if isIPv6 := addr.IP.To4() == nil; isIPv6 != tt.wantIPv6 {
+			t.Errorf("newListener(%q, %q) listens on %s, want IPv6 %t", tt.network, tt.addr, addr, tt.wantIPv6)
+		}
+		l.Close()
+	}
+}
+
+func TestScrapeIntervalMetric(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     float64
+	}{
+		{interval: time.Minute, want: 60},
+		{interval: 5 * time.Minute, want: 300},
+		{interval: 1500 * time.Millisecond, want: 1.5},
+	}
+	for _, tt := range tests {
+		setConfig(t, &cfgScrapeInterval, tt.interval)
+		exportStartupMetrics()
+		if got := testutil.ToFloat64(cfScrapeIntervalSeconds); got != tt.want {
+			t.Errorf("-scrape_interval %s: got cloudflare_stream_scrape_interval_seconds %v, want %v", tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestMinutesViewedUnit(t *testing.T) {
+	tests := []struct {
+		unit     string
+		wantName string
+		want     float64
+	}{
+		{unit: "minutes", wantName: "cloudflare_streaming_minutes_viewed", want: 2.5},
+		{unit: "seconds", wantName: "cloudflare_stream_seconds_viewed", want: 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			setConfig(t, &cfgViewedUnit, tt.unit)
+			resetMetrics(t)
+
+			setMinutesViewed(testAccount(), 2.5)
+
+			got, ok := gatheredValue(t, tenants.all(), tt.wantName, accountLabels(testAccount()))
+			if !ok || got != tt.want {
+				t.Errorf("got %s %v (exported %t), want %v", tt.wantName, got, ok, tt.want)
+			}
+		})
+	}
+
+	if err := registerViewedMetric("hours"); err == nil {
+		t.Error("registerViewedMetric(\"hours\") succeeded, want an error")
+	}
+}
+
+func TestValuePrecision(t *testing.T) {
+	tests := []struct {
+		precision int
+		in, want  float64
+	}{
+		{-1, 100.0 / 3, 100.0 / 3},
+		{0, 100.0 / 3, 33},
+		{2, 100.0 / 3, 33.33},
+		{2, 2.0 / 3, 0.67},
+		{1, 0.05, 0.1},
+		{3, 12, 12},
+	}
+	for _, tt := range tests {
+		setConfig(t, &cfgValuePrecision, tt.precision)
+		if got := roundValue(tt.in); got != tt.want {
+			t.Errorf("-value_precision %d: roundValue(%v) = %v, want %v", tt.precision, tt.in, got, tt.want)
+		}
+	}
+
+	// 100 minutes over 3 buckets.
+	setConfig(t, &cfgValuePrecision, 2)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, mockFixture{method: http.MethodPost, path: "/graphql/", body: `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [
+		{"sum": {"minutesViewed": 50}, "dimensions": {"ts": "2022-09-01T10:00:00Z"}},
+		{"sum": {"minutesViewed": 30}, "dimensions": {"ts": "2022-09-01T10:05:00Z"}},
+		{"sum": {"minutesViewed": 20}, "dimensions": {"ts": "2022-09-01T10:10:00Z"}}
+	]}]}}}`})
+	fetchStreamingAnalytics(context.Background(), testAccount())
+	if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(testAccount())); !ok || got != 33.33 {
+		t.Errorf("got minutes viewed %v (exported %t), want 33.33", got, ok)
+	}
+}
+
+func TestAlignDelay(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		now      string
+		interval time.Duration
+		want     time.Duration
+	}{
+		{now: "2022-09-01T10:00:17Z", interval: time.Minute, want: 43 * time.Second},
+		{now: "2022-09-01T10:00:59.5Z", interval: time.Minute, want: 500 * time.Millisecond},
+		{now: "2022-09-01T10:00:00Z", interval: time.Minute, want: 0},
+		{now: "2022-09-01T10:03:00Z", interval: 5 * time.Minute, want: 2 * time.Minute},
+		{now: "2022-09-01T10:03:00Z", interval: 0, want: 0},
+	}
+	for _, tt := range tests {
+		now := at(tt.now)
+		got := alignDelay(now, tt.interval)
+		if got != tt.want {
+			t.Errorf("alignDelay(%s, %s) = %s, want %s", tt.now, tt.interval, got, tt.want)
+		}
+		if tt.interval > 0 && !now.Add(got).Equal(now.Add(got).Truncate(tt.interval)) {
+			t.Errorf("alignDelay(%s, %s) lands on %s, not on a %s boundary", tt.now, tt.interval, now.Add(got), tt.interval)
+		}
+	}
+}
+
+func TestSlowAccountTimeout(t *testing.T) {
+	setConfig(t, &cfgRequestTimeout, 100*time.Millisecond)
+	setConfig(t, &cfgConcurrency, 1)
+	setConfig(t, &cfgMaxRetries, 0)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	slow := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+	slow.account = testAccount().ID
+	slow.delay = time.Minute
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		slow,
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	start := time.Now()
+	fetchMetrics(context.Background())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("scrape took %s, want the slow account cut at -request_timeout", elapsed)
+	}
+
+	accounts := lastAccounts.all()
+	if len(accounts) != 2 {
+		t.Fatalf("got %d accounts, want 2", len(accounts))
+	}
+	for _, a := range accounts {
+		_, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(a))
+		if want := a.ID != testAccount().ID; ok != want {
+			t.Errorf("%s exported %t, want %t", a.Name, ok, want)
+		}
+	}
+}
+
+func TestExporterStartTime(t *testing.T) {
+	before := time.Now()
+	exportStartupMetrics()
+
+	got := testutil.ToFloat64(cfExporterStartTime)
+	if got < float64(before.Unix()) || got > float64(time.Now().Unix()+1) {
+		t.Errorf("got cloudflare_stream_exporter_start_time_seconds %v, want about %d", got, before.Unix())
+	}
+}
+
+func TestAccountsPageSize(t *testing.T) {
+	setConfig(t, &cfgAccountsPageSize, 2)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts_page1.json"),
+		restFixture(http.MethodGet, "/accounts", "accounts_page2.json"),
+	)
+
+	accounts, err := fetchAccounts(context.Background(), testAccount().token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, a := range accounts {
+		ids = append(ids, a.ID)
+	}
+	if want := []string{"a1", "a2", "a3"}; !equalStrings(ids, want) {
+		t.Errorf("got accounts %v, want %v", ids, want)
+	}
+
+	requests := m.requests("/client/v4/accounts")
+	if len(requests) != 2 {
+		t.Fatalf("got %d account pages requested, want 2", len(requests))
+	}
+	for i, r := range requests {
+		if got, want := r.params.Get("page"), fmt.Sprint(i+1); got != want {
+			t.Errorf("request %d asked for page %s, want %s", i, got, want)
+		}
+		if got := r.params.Get("per_page"); got != "2" {
+			t.Errorf("request %d asked for per_page %s, want -accounts_page_size 2", i, got)
+		}
+	}
+}
+
+func TestAPIClientOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		wantErr    bool
+		requests   int
+	}{
+		{"retried", 1, false, 2},
+		{"retries disabled", 0, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgCfMaxRetries, tt.maxRetries)
+			resetMetrics(t)
+			failing := restFixture(http.MethodGet, "/accounts", "")
+			failing.status = http.StatusInternalServerError
+			failing.body = "upstream unavailable"
+			m := newMockCloudflare(t, failing, restFixture(http.MethodGet, "/accounts", "accounts.json"))
+
+			api, err := newAPIClient(testAccount().token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if api.BaseURL != cfAPIEndpoint {
+				t.Errorf("got base url %s, want -cf_api_endpoint %s", api.BaseURL, cfAPIEndpoint)
+			}
+
+			_, err = fetchAccounts(context.Background(), testAccount().token)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("fetchAccounts() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got := len(m.requests("/client/v4/accounts")); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+			// The client sends through the instrumented transport.
+			if got := histogramCount(t, prometheus.DefaultGatherer, "cloudflare_stream_api_request_duration_seconds", prometheus.Labels{"endpoint": "accounts"}); got != uint64(tt.requests) {
+				t.Errorf("observed %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestSlowScrapes(t *testing.T) {
+	tests := []struct {
+		name string
+		slo  time.Duration
+		want float64
+	}{
+		{"past the slo", 100 * time.Millisecond, 1},
+		{"within the slo", 5 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgScrapeSLO, tt.slo)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			accounts := restFixture(http.MethodGet, "/accounts", "accounts_empty.json")
+			accounts.delay = 200 * time.Millisecond
+			newMockCloudflare(t, accounts)
+			before := testutil.ToFloat64(cfSlowScrapes)
+
+			fetchMetrics(context.Background())
+
+			if got := testutil.ToFloat64(cfSlowScrapes) - before; got != tt.want {
+				t.Errorf("slow scrapes grew by %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrapePanicRecovered(t *testing.T) {
+	tests := []struct {
+		name   string
+		inject func(t *testing.T)
+	}{
+		// The worker scraping the account panics on the unregistered metric.
+		{"in an account worker", func(t *testing.T) { setConfig(t, &cfStreamingMinutesViewed, nil) }},
+		// fetchMetrics itself panics on the missing rest limiter.
+		{"in the cycle", func(t *testing.T) { setConfig(t, &restLimit, nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfIncludeAccounts, testAccount().ID)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t,
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+			)
+			tt.inject(t)
+			before := testutil.ToFloat64(cfScrapePanics)
+
+			for i := 0; i < 2; i++ {
+				if !tryScrapeAndPush(context.Background()) {
+					t.Fatalf("cycle %d did not run, the panic left the scrape locked", i)
+				}
+			}
+
+			if got := testutil.ToFloat64(cfScrapePanics) - before; got != 2 {
+				t.Errorf("scrape panics grew by %v over 2 cycles, want 2", got)
+			}
+		})
+	}
+}
+
+func TestGraphQLOperationPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "query StreamMinutesViewed("},
+		{"eu_west_", "query eu_west_StreamMinutesViewed("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if err := validateOperationPrefix(tt.prefix); err != nil {
+				t.Fatal(err)
+			}
+			setConfig(t, &cfgGraphQLOperationPrefix, tt.prefix)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			m := newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			requests := m.requests("/graphql/")
+			if len(requests) != 1 || !strings.Contains(requests[0].query, tt.want) {
+				t.Errorf("got queries %v, want one with operation %q", requests, tt.want)
+			}
+		})
+	}
+
+	for _, prefix := range []string{"eu-west", "1st_", "a b"} {
+		if err := validateOperationPrefix(prefix); err == nil {
+			t.Errorf("validateOperationPrefix(%q) accepted a prefix that is not a graphql name", prefix)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	resetMetrics(t)
+	setConfig(t, &http.DefaultServeMux, http.NewServeMux())
+	http.Handle("/metrics", allowMethods(metricsHandler(), readMethods...))
+	exportStartupMetrics()
+
+	// No cloudflare endpoint answers, the metric is served before any fetch.
+	setConfig(t, &cfAPIEndpoint, "http://127.0.0.1:1/client/v4")
+	addr := freeAddr(t)
+	server, err := serve("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if err := selfTest("/metrics"); err != nil {
+		t.Errorf("selfTest() = %s", err)
+	}
+	if err := selfTest("/missing"); err == nil {
+		t.Error("selfTest() passed on a path serving nothing")
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	families, err := new(expfmt.TextParser).TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, ok := families["cloudflare_stream_self_test"]
+	if !ok || mf.GetMetric()[0].GetGauge().GetValue() != 1 {
+		t.Errorf("got %v, want cloudflare_stream_self_test 1", mf)
+	}
+}
+
+func TestScrapeCycles(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t)
+	failing := restFixture(http.MethodGet, "/accounts", "rest_error.json")
+	failing.status = http.StatusForbidden
+	ok := restFixture(http.MethodGet, "/accounts", "")
+	ok.body = readTestdata(t, "accounts.json")
+
+	cycles := []struct {
+		name    string
+		fixture mockFixture
+		strict  bool
+	}{
+		{"success", ok, false},
+		{"accounts failing", failing, false},
+		{"filters excluding all", ok, true},
+	}
+	for i, cycle := range cycles {
+		m.mu.Lock()
+		m.fixtures = []mockFixture{cycle.fixture, graphqlFixture("StreamMinutesViewed", "")}
+		m.mu.Unlock()
+		setConfig(t, &cfgStrictFilters, cycle.strict)
+		if cycle.strict {
+			setConfig(t, &cfgExcludeAccounts, testAccount().ID+",7c5dae5552338874e5053f2534d2767a")
+		}
+		before := testutil.ToFloat64(cfScrapeCycles)
+
+		fetchMetrics(context.Background())
+
+		if got := testutil.ToFloat64(cfScrapeCycles) - before; got != 1 {
+			t.Errorf("cycle %d (%s): scrape cycles grew by %v, want 1", i, cycle.name, got)
+		}
+	}
+}
+
+func TestMinMinutesViewed(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	tests := []struct {
+		threshold float64
+		want      []string
+	}{
+		{0, []string{testAccount().ID, staging}},
+		// 240 minutes for acme, 5 for staging.
+		{100, []string{testAccount().ID}},
+		{240, []string{testAccount().ID}},
+		{241, nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.threshold), func(t *testing.T) {
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			acme := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+			acme.account = testAccount().ID
+			other := graphqlFixture("StreamMinutesViewed", "")
+			other.account, other.body = staging, `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [{"sum": {"minutesViewed": 5}, "dimensions": {"ts": "2022-09-01T10:00:00Z"}}]}]}}}`
+			newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"), acme, other)
+
+			// A first cycle without threshold, so suppressed accounts must be deleted.
+			fetchMetrics(context.Background())
+			setConfig(t, &cfgMinMinutesViewed, tt.threshold)
+			fetchMetrics(context.Background())
+
+			var got []string
+			for _, id := range []string{testAccount().ID, staging} {
+				if n := countSeries(t, tenants.account(id), "cloudflare_streaming_minutes_viewed"); n > 0 {
+					got = append(got, id)
+				}
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("got minutes viewed exported for %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortAccounts(t *testing.T) {
+	tests := []struct {
+		by   string
+		want []string
+	}{
+		{"", []string{"c", "a", "b"}},
+		{"id", []string{"a", "b", "c"}},
+		{"name", []string{"b", "a", "c"}},
+	}
+	for _, tt := range tests {
+		// Other Corp, Acme Streaming, Acme Staging.
+		accounts := testAccounts()
+		accounts = []monitoredAccount{accounts[2], accounts[0], accounts[1]}
+		sortAccounts(accounts, tt.by)
+		if got := accountIDs(accounts); !equalStrings(got, tt.want) {
+			t.Errorf("-sort_accounts %q: got %v, want %v", tt.by, got, tt.want)
+		}
+	}
+
+	// Cloudflare lists Acme Streaming first, its name sorts after Acme Staging.
+	setConfig(t, &cfgSortAccounts, "name")
+	setConfig(t, &cfgConcurrency, 1)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+	fetchMetrics(context.Background())
+
+	var got []string
+	for _, r := range m.requests("/graphql/") {
+		got = append(got, fmt.Sprint(r.variables["accountID"]))
+	}
+	if want := []string{"7c5dae5552338874e5053f2534d2767a", testAccount().ID}; !equalStrings(got, want) {
+		t.Errorf("queried accounts in order %v, want %v", got, want)
+	}
+}
+
+func TestPartialDatasetFailure(t *testing.T) {
+	failing := func(operation string) mockFixture {
+		f := graphqlFixture(operation, "")
+		f.status, f.body = http.StatusInternalServerError, "upstream unavailable"
+		return f
+	}
+	tests := []struct {
+		name     string
+		fixtures []mockFixture
+		want     map[string]bool
+	}{
+		{
+			name: "top videos failing",
+			fixtures: []mockFixture{
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+				failing("StreamTopVideos"),
+				graphqlFixture("StreamUniqueViewers", "unique_viewers.json"),
+			},
+			want: map[string]bool{"cloudflare_streaming_minutes_viewed": true, "cloudflare_stream_video_minutes_viewed": false, "cloudflare_stream_unique_viewers": true},
+		},
+		{
+			name: "minutes viewed failing",
+			fixtures: []mockFixture{
+				failing("StreamMinutesViewed"),
+				graphqlFixture("StreamTopVideos", "top_videos.json"),
+				graphqlFixture("StreamUniqueViewers", "unique_viewers.json"),
+			},
+			want: map[string]bool{"cloudflare_streaming_minutes_viewed": false, "cloudflare_stream_video_minutes_viewed": true, "cloudflare_stream_unique_viewers": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgMaxRetries, 0)
+			setConfig(t, &cfgTopVideos, 2)
+			setConfig(t, &cfgUniqueViewers, true)
+			setConfig(t, &videoNames, &videoNameCache{names: map[string]string{}})
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, tt.fixtures...)
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			for name, want := range tt.want {
+				if got := countSeries(t, tenants.all(), name) > 0; got != want {
+					t.Errorf("%s exported = %t, want %t", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomStreamDataset(t *testing.T) {
+	setConfig(t, &cfgStreamDataset, "streamViewsAdaptiveGroups")
+	setConfig(t, &cfgStreamField, "watchMinutes")
+	if err := validateStreamDataset(cfgStreamDataset, cfgStreamField); err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, field, order := "streamMinutesViewedAdaptiveGroups: streamViewsAdaptiveGroups(", "minutesViewed: watchMinutes", "sum_watchMinutes_DESC"
+	queries := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"minutes viewed", buildStreamingQuery(false), []string{dataset, field, order}},
+		{"batch", buildStreamingQuery(true), []string{dataset, field, order}},
+		{"top videos", buildTopVideosQuery(), []string{dataset, field, order}},
+		// Ordered by date.
+		{"historical", buildHistoricalQuery(), []string{dataset, field}},
+		// A single row of uniq, no summed field.
+		{"unique viewers", buildUniqueViewersQuery(), []string{dataset}},
+	}
+	for _, q := range queries {
+		for _, want := range q.want {
+			if !strings.Contains(q.query, want) {
+				t.Errorf("%s query misses %q:\n%s", q.name, want, q.query)
+			}
+		}
+	}
+
+	// The aliases decode the response like the default dataset.
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+	fetchStreamingAnalytics(context.Background(), testAccount())
+	if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(testAccount())); !ok || got != 80 {
+		t.Errorf("got minutes viewed %v (exported %t), want 80", got, ok)
+	}
+
+	for _, tt := range []struct{ dataset, field string }{
+		{"stream-views", "minutesViewed"},
+		{"streamMinutesViewedAdaptiveGroups", "minutes viewed"},
+		{"", "minutesViewed"},
+	} {
+		if err := validateStreamDataset(tt.dataset, tt.field); err == nil {
+			t.Errorf("validateStreamDataset(%q, %q) accepted names that are not graphql names", tt.dataset, tt.field)
+		}
+	}
+}
+
+func TestMaxScrapeDuration(t *testing.T) {
+	const n = 10
+	setConfig(t, &cfgMaxScrapeDuration, 300*time.Millisecond)
+	setConfig(t, &cfgConcurrency, 1)
+	setConfig(t, &cfgMaxRetries, 0)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	var result []string
+	for i := 0; i < n; i++ {
+		result = append(result, fmt.Sprintf(`{"id": "%032x", "name": "Account %d", "type": "standard"}`, i, i))
+	}
+	accounts := mockFixture{method: http.MethodGet, path: "/client/v4/accounts", body: `{"success": true, "errors": [], "messages": [], "result": [` +
+		strings.Join(result, ",") + fmt.Sprintf(`], "result_info": {"page": 1, "per_page": 50, "total_pages": 1, "count": %d, "total_count": %d}}`, n, n)}
+	slow := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+	slow.delay = 100 * time.Millisecond
+	m := newMockCloudflare(t, accounts, slow)
+	skipped := testutil.ToFloat64(cfAccountsSkipped.WithLabelValues(skipReasonDeadline))
+
+	start := time.Now()
+	fetchMetrics(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*cfgMaxScrapeDuration {
+		t.Errorf("scrape took %s, want it stopped at -max_scrape_duration %s", elapsed, cfgMaxScrapeDuration)
+	}
+
+	fetched := len(m.requests("/graphql/"))
+	if fetched == 0 || fetched >= n {
+		t.Fatalf("got %d accounts queried, want some but not all of %d", fetched, n)
+	}
+	if got := testutil.ToFloat64(cfAccountsSkipped.WithLabelValues(skipReasonDeadline)) - skipped; got != float64(n-fetched) {
+		t.Errorf("got %v accounts skipped past the deadline, want the %d not queried", got, n-fetched)
+	}
+	var exported, failed int
+	for _, a := range lastAccounts.all() {
+		if _, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(a)); ok {
+			exported++
+		}
+		if v, _ := gatheredValue(t, tenants.all(), "cloudflare_stream_consecutive_scrape_failures", accountLabels(a)); v == 1 {
+			failed++
+		}
+	}
+	if exported+failed != n || failed < n-fetched {
+		t.Errorf("got %d accounts exported and %d failed, want the %d skipped ones among the failed", exported, failed, n-fetched)
+	}
+}
+
+func TestDatasetConcurrency(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	tests := []struct {
+		concurrency int
+		parallel    bool
+	}{
+		{1, false},
+		{3, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.concurrency), func(t *testing.T) {
+			setConfig(t, &cfgDatasetConcurrency, tt.concurrency)
+			setConfig(t, &cfgTopVideos, 2)
+			setConfig(t, &cfgUniqueViewers, true)
+			setConfig(t, &cfgFetchZoneCounts, true)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			// Names known already, so only the datasets hit the mock.
+			setConfig(t, &videoNames, &videoNameCache{names: map[string]string{
+				"ea95132c15732412d22c1476fa83f27a": "Keynote 2022",
+				"0e1b3ddd4e8c4e9aab9e154ba5145511": "",
+			}})
+			resetMetrics(t)
+			fixtures := []mockFixture{
+				graphqlFixture("StreamTopVideos", "top_videos.json"),
+				graphqlFixture("StreamUniqueViewers", "unique_viewers.json"),
+				restFixture(http.MethodGet, "/zones", "zones.json"),
+			}
+			for i := range fixtures {
+				fixtures[i].delay = delay
+			}
+			m := newMockCloudflare(t, fixtures...)
+
+			end := time.Now()
+			fetchAccountDatasets(context.Background(), testAccount(), end.Add(-cfgLookback), end)
+
+			var at []time.Time
+			for _, path := range []string{"/graphql/", "/client/v4/zones"} {
+				for _, r := range m.requests(path) {
+					at = append(at, r.at)
+				}
+			}
+			if len(at) != 3 {
+				t.Fatalf("got %d dataset requests, want 3", len(at))
+			}
+			first, last := at[0], at[0]
+			for _, a := range at {
+				if a.Before(first) {
+					first = a
+				}
+				if a.After(last) {
+					last = a
+				}
+			}
+			if spread := last.Sub(first); (spread < delay/2) != tt.parallel {
+				t.Errorf("got the datasets queried %s apart, want parallel %t", spread, tt.parallel)
+			}
+
+			g := tenants.all()
+			if got := countSeries(t, g, "cloudflare_stream_video_minutes_viewed"); got != 2 {
+				t.Errorf("got %d video series, want 2", got)
+			}
+			if got, ok := gatheredValue(t, g, "cloudflare_stream_unique_viewers", accountLabels(testAccount())); !ok || got != 42 {
+				t.Errorf("got unique viewers %v (exported %t), want 42", got, ok)
+			}
+			if got := countSeries(t, g, "cloudflare_stream_account_info"); got != 1 {
+				t.Errorf("got %d account info series, want 1", got)
+			}
+		})
+	}
+}
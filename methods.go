@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowMethods rejects requests with any other method than the given ones
+// with 405, advertising the allowed methods in the Allow header.
+func allowMethods(next http.Handler, methods ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !contains(methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var readMethods = []string{http.MethodGet, http.MethodHead}
+
+// refreshHandler runs a scrape cycle right away instead of waiting for the
+// next tick, and answers once it finished. It answers 409 without scraping
+// while a cycle is running, and the cycle is cancelled if the client leaves.
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if !tryScrapeAndPush(r.Context()) {
+		http.Error(w, "a scrape is already running", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowMethods(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts_empty.json"))
+	metrics := allowMethods(metricsHandler(), readMethods...)
+	refresh := allowMethods(http.HandlerFunc(refreshHandler), http.MethodPost)
+
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		method    string
+		status    int
+		wantAllow string
+	}{
+		{"GET /metrics", metrics, http.MethodGet, http.StatusOK, ""},
+		{"HEAD /metrics", metrics, http.MethodHead, http.StatusOK, ""},
+		{"POST /metrics", metrics, http.MethodPost, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"DELETE /metrics", metrics, http.MethodDelete, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"POST /-/refresh", refresh, http.MethodPost, http.StatusNoContent, ""},
+		{"GET /-/refresh", refresh, http.MethodGet, http.StatusMethodNotAllowed, "POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/", nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestRefreshWhileScraping(t *testing.T) {
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+
+	rec := httptest.NewRecorder()
+	refreshHandler(rec, httptest.NewRequest(http.MethodPost, "/-/refresh", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got status %d while a scrape is running, want 409", rec.Code)
+	}
+}
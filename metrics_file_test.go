@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cloudflare_stream.prom")
+	setConfig(t, &cfgMetricsFile, path)
+	setConfig(t, &cfIncludeAccounts, testAccount().ID)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	cycles := []struct {
+		body string
+		want float64
+	}{
+		{"", 80},
+		{`{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [{"sum": {"minutesViewed": 30}, "dimensions": {"ts": "2022-09-01T10:15:00Z"}}]}]}}}`, 30},
+	}
+	for i, cycle := range cycles {
+		if len(cycle.body) > 0 {
+			m.mu.Lock()
+			m.fixtures = append(m.fixtures[:1], mockFixture{method: http.MethodPost, path: "/graphql/", body: cycle.body})
+			m.mu.Unlock()
+		}
+		runScrapeAndPush(context.Background())
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		families, err := new(expfmt.TextParser).TextToMetricFamilies(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("cycle %d: invalid exposition format: %s", i, err)
+		}
+		mf, ok := families["cloudflare_streaming_minutes_viewed"]
+		if !ok || len(mf.GetMetric()) != 1 {
+			t.Fatalf("cycle %d: got %v, want one minutes viewed series", i, mf)
+		}
+		if got := mf.GetMetric()[0].GetGauge().GetValue(); got != cycle.want {
+			t.Errorf("cycle %d: got minutes viewed %v in the file, want %v", i, got, cycle.want)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("got mode %s, want the file readable by the collector", info.Mode())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files in the directory, want the temporary files renamed", len(entries))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// accountSet holds the accounts monitored by the last scrape. Readers get a
+// snapshot, so a scrape replacing the set does not race with them.
+type accountSet struct {
+	mu       sync.RWMutex
+	accounts []monitoredAccount
+}
+
+var lastAccounts = &accountSet{}
+
+func (s *accountSet) set(accounts []monitoredAccount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts = append([]monitoredAccount(nil), accounts...)
+}
+
+func (s *accountSet) all() []monitoredAccount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]monitoredAccount(nil), s.accounts...)
+}
+
+func (s *accountSet) byID(id string) (monitoredAccount, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, a := range s.accounts {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	return monitoredAccount{}, false
+}
+
+// accountGatherer only keeps the series of one account, matched by the
+// account_id label or by the account name when only names are exported.
+type accountGatherer struct {
+	next prometheus.Gatherer
+	id   string
+	name string
+}
+
+func (g accountGatherer) matches(m *dto.Metric) bool {
+	name := false
+	for _, l := range m.Label {
+		switch l.GetName() {
+		case "account_id":
+			return l.GetValue() == g.id
+		case "account":
+			name = l.GetValue() == g.name
+		}
+	}
+	return name
+}
+
+func (g accountGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.next.Gather()
+
+	filtered := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		var metrics []*dto.Metric
+		for _, m := range mf.Metric {
+			if g.matches(m) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			filtered = append(filtered, mf)
+		}
+	}
+
+	return filtered, err
+}
+
+// metricsHandler serves all metrics, or with ?account=<id> only the series of
+// that account so each tenant can scrape its own.
+func metricsHandler() http.Handler {
+	all := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("account")
+		if len(id) == 0 {
+			all.ServeHTTP(w, r)
+			return
+		}
+
+		serveAccountMetrics(w, r, id)
+	})
+}
+
+// tenantHandler serves the tenant registries of the account named by the
+// path below prefix, e.g. /metrics/<id>. Unlike ?account= nothing is filtered
+// out of shared metrics, the account's metrics live in registries of its own.
+func tenantHandler(prefix string, constLabels prometheus.Labels) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if len(id) == 0 || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if _, ok := lastAccounts.byID(id); !ok {
+			http.Error(w, "account "+id+" is not monitored", http.StatusNotFound)
+			return
+		}
+
+		promhttp.HandlerFor(newConstLabelGatherer(tenants.account(id), constLabels), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+func serveAccountMetrics(w http.ResponseWriter, r *http.Request, id string) {
+	account, ok := lastAccounts.byID(id)
+	if !ok {
+		http.Error(w, "account "+id+" is not monitored", http.StatusNotFound)
+		return
+	}
+	promhttp.HandlerFor(accountGatherer{next: gatherer, id: account.ID, name: accountDisplayName(account)}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
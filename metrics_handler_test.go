@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestAccountMetrics(t *testing.T) {
+	resetMetrics(t)
+	acme := testAccount()
+	other := monitoredAccount{Account: cloudflare.Account{ID: "7c5dae5552338874e5053f2534d2767a", Name: "Acme Staging"}, token: acme.token}
+	setConfig(t, &lastAccounts, &accountSet{})
+	lastAccounts.set([]monitoredAccount{acme, other})
+	setMinutesViewed(acme, 80)
+	setMinutesViewed(other, 5)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		target  string
+		status  int
+		want    []string
+		notWant []string
+	}{
+		{"all accounts", metricsHandler(), "/metrics", http.StatusOK, []string{acme.ID, other.ID}, nil},
+		{"account query", metricsHandler(), "/metrics?account=" + acme.ID, http.StatusOK, []string{`account_id="` + acme.ID + `"} 80`}, []string{other.ID}},
+		{"unknown account query", metricsHandler(), "/metrics?account=unknown", http.StatusNotFound, []string{"not monitored"}, nil},
+		{"tenant path", tenantHandler("/metrics/", nil), "/metrics/" + other.ID, http.StatusOK, []string{`account_id="` + other.ID + `"} 5`}, []string{acme.ID}},
+		{"unknown tenant path", tenantHandler("/metrics/", nil), "/metrics/unknown", http.StatusNotFound, []string{"not monitored"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			body, _ := io.ReadAll(rec.Body)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, body)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(body), s) {
+					t.Errorf("%q missing from %s", s, body)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(string(body), s) {
+					t.Errorf("%q in %s, want it filtered out", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestAccountSetSnapshot(t *testing.T) {
+	accounts := []monitoredAccount{testAccount()}
+	set := &accountSet{}
+	set.set(accounts)
+
+	accounts[0].Name = "Renamed by the caller"
+	snapshot := set.all()
+	if snapshot[0].Name != testAccount().Name {
+		t.Errorf("got account %q, want the set not to share the slice it was given", snapshot[0].Name)
+	}
+	snapshot[0].Name = "Renamed by a reader"
+	if got := set.all()[0].Name; got != testAccount().Name {
+		t.Errorf("got account %q, want readers not to share the set's slice", got)
+	}
+}
+
+// TestAccountsRefreshWhileScraping refreshes the accounts with scrapes while
+// readers walk the last accounts, run with -race to catch unguarded access.
+func TestAccountsRefreshWhileScraping(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &lastAccounts, &accountSet{})
+	setConfig(t, &accountNames, &accountNameCache{accounts: map[string]monitoredAccount{}})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+	handler := tenantHandler("/metrics/", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			fetchMetrics(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				for _, a := range lastAccounts.all() {
+					a.Name += " (read)"
+					accountNames.set(a)
+					accountNames.get(a.ID)
+				}
+				lastAccounts.byID(testAccount().ID)
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics/"+testAccount().ID, nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(lastAccounts.all()); got != 2 {
+		t.Errorf("got %d accounts after the refreshes, want the two of testdata/accounts.json", got)
+	}
+	if a, ok := lastAccounts.byID(testAccount().ID); !ok || a.Name != testAccount().Name {
+		t.Errorf("got account %v (found %t), want readers not to modify the cached accounts", a, ok)
+	}
+}
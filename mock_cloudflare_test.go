@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+// mockFixture is a canned response of the mock cloudflare. Graphql fixtures
+// match on the operation name of the query, rest fixtures on method and path.
+type mockFixture struct {
+	method    string
+	path      string
+	operation string
+	// token and account, when set, restrict the fixture to requests made
+	// with that token or querying that account.
+	token   string
+	account string
+	// delay holds the response back, or until the client gives up.
+	delay  time.Duration
+	status int
+	header http.Header
+	// file is read from testdata, body is used when it is empty.
+	file string
+	body string
+}
+
+func graphqlFixture(operation, file string) mockFixture {
+	return mockFixture{method: http.MethodPost, path: "/graphql/", operation: operation, file: file}
+}
+
+func restFixture(method, path, file string) mockFixture {
+	return mockFixture{method: method, path: "/client/v4" + path, file: file}
+}
+
+// mockRequest is a request received by the mock cloudflare.
+type mockRequest struct {
+	method    string
+	path      string
+	params    url.Values
+	header    http.Header
+	query     string
+	variables map[string]interface{}
+	at        time.Time
+}
+
+type mockCloudflare struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	fixtures []mockFixture
+	received []mockRequest
+}
+
+// newMockCloudflare serves fixtures on an httptest server and points the
+// graphql and rest endpoints at it for the duration of the test. When several
+// fixtures match a request the first one is served and dropped, the last one
+// keeps answering, so a test can line up e.g. a 429 before a 200.
+func newMockCloudflare(t *testing.T, fixtures ...mockFixture) *mockCloudflare {
+	t.Helper()
+
+	for i, f := range fixtures {
+		if len(f.file) > 0 {
+			fixtures[i].body = readTestdata(t, f.file)
+		}
+	}
+
+	m := &mockCloudflare{t: t, fixtures: fixtures}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.Close)
+
+	setConfig(t, &cfAPIEndpoint, m.URL+"/client/v4")
+	setConfig(t, &cfGraphQLEndpoint, m.URL+"/graphql/")
+	endpoints, err := parseGraphQLEndpoints(cfGraphQLEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, &graphqlEndpoints, endpoints)
+	setConfig(t, &apiClients, map[string]*cloudflare.API{})
+	setConfig(t, &restLimit, &restLimiters{limiters: map[string]*rate.Limiter{}})
+	// Tests of the rest rate limit lower it again after this.
+	setConfig(t, &cfgCfRateLimit, 1000.0)
+
+	return m
+}
+
+func readTestdata(t *testing.T, file string) string {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func (m *mockCloudflare) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	received := mockRequest{method: r.Method, path: r.URL.Path, params: r.URL.Query(), header: r.Header.Clone(), at: time.Now()}
+	if strings.HasPrefix(r.URL.Path, "/graphql") {
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &body); err != nil {
+			m.t.Errorf("Decoding graphql request: %s", err)
+		}
+		received.query, received.variables = body.Query, body.Variables
+	}
+
+	m.mu.Lock()
+	m.received = append(m.received, received)
+	f, ok := m.match(received)
+	m.mu.Unlock()
+
+	if !ok {
+		m.t.Logf("No fixture for %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	for name, values := range f.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	io.WriteString(w, f.body)
+}
+
+// match returns the fixture answering the request, m.mu must be held.
+func (m *mockCloudflare) match(r mockRequest) (mockFixture, bool) {
+	var matching []int
+	for i, f := range m.fixtures {
+		if f.method != r.method || f.path != r.path {
+			continue
+		}
+		if len(f.token) > 0 && r.header.Get("Authorization") != "Bearer "+f.token {
+			continue
+		}
+		if len(f.account) > 0 && r.variables["accountID"] != f.account {
+			continue
+		}
+		if len(f.operation) > 0 && !strings.Contains(r.query, operationName(f.operation)+"(") {
+			continue
+		}
+		matching = append(matching, i)
+	}
+	if len(matching) == 0 {
+		return mockFixture{}, false
+	}
+
+	f := m.fixtures[matching[0]]
+	if len(matching) > 1 {
+		m.fixtures = append(m.fixtures[:matching[0]], m.fixtures[matching[0]+1:]...)
+	}
+	return f, true
+}
+
+// requests returns the requests received so far on path.
+func (m *mockCloudflare) requests(path string) []mockRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var requests []mockRequest
+	for _, r := range m.received {
+		if r.path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// setConfig sets a setting or other global for the duration of the test.
+func setConfig[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// resetMetrics registers the metrics registerAccountMetrics sets up at
+// startup in fresh registries, after the test adjusted the settings they
+// depend on. The metrics registered at init stay in the default registry.
+func resetMetrics(t *testing.T) {
+	t.Helper()
+
+	reg := prometheus.NewRegistry()
+	setConfig(t, &prometheus.DefaultRegisterer, prometheus.Registerer(reg))
+	setConfig(t, &prometheus.DefaultGatherer, prometheus.Gatherer(prometheus.Gatherers{initRegistry, reg}))
+	setConfig(t, &tenants, &tenantRegistries{regs: map[string]map[string]*prometheus.Registry{}})
+	setConfig(t, &seriesLimit, &seriesLimiter{seen: map[string]struct{}{}})
+	setConfig(t, &cfStreamingMinutesViewed, nil)
+	setConfig(t, &cfBucketMinutesViewed, nil)
+	setConfig(t, &cfBucketMinutesHistogram, nil)
+	setConfig(t, &gatherer, allGatherers())
+
+	registerAPIMetrics(defaultHistogramBuckets)
+	if err := registerAccountMetrics(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// initRegistry holds the metrics registered by the package at init.
+var initRegistry = prometheus.DefaultGatherer
+
+// testAccount is an account of testdata/accounts.json.
+func testAccount() monitoredAccount {
+	return monitoredAccount{
+		Account: cloudflare.Account{ID: "023e105f4ecef8ad9ca31a8372d0c353", Name: "Acme Streaming"},
+		token:   apiToken{name: "token0", value: "test-token"},
+	}
+}
+
+// gatheredValue returns the value of the series of name with labels in g, and
+// whether there is one.
+func gatheredValue(t *testing.T, g prometheus.Gatherer, name string, labels prometheus.Labels) (float64, bool) {
+	t.Helper()
+
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range family.GetMetric() {
+			got := map[string]string{}
+			for _, l := range m.GetLabel() {
+				got[l.GetName()] = l.GetValue()
+			}
+			if len(got) != len(labels) {
+				continue
+			}
+			for k, v := range labels {
+				if got[k] != v {
+					continue series
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				return m.GetGauge().GetValue(), true
+			case m.Counter != nil:
+				return m.GetCounter().GetValue(), true
+			case m.Untyped != nil:
+				return m.GetUntyped().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// countSeries returns the number of series of name in g.
+func countSeries(t *testing.T, g prometheus.Gatherer, name string) int {
+	t.Helper()
+
+	n, err := testutil.GatherAndCount(g, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMockCloudflare(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	accounts, err := fetchAllAccounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[0].ID != testAccount().ID {
+		t.Fatalf("got accounts %v, want the two of testdata/accounts.json", accounts)
+	}
+
+	fetchStreamingAnalytics(context.Background(), accounts[0])
+
+	requests := m.requests("/graphql/")
+	if len(requests) != 1 {
+		t.Fatalf("got %d graphql requests, want 1", len(requests))
+	}
+	if got := requests[0].variables["accountID"]; got != testAccount().ID {
+		t.Errorf("queried account %v, want %s", got, testAccount().ID)
+	}
+	if got := requests[0].header.Get("Authorization"); got != "Bearer test-token" {
+		t.Errorf("got Authorization %q, want the account token", got)
+	}
+
+	// 240 minutes over 3 buckets.
+	got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(accounts[0]))
+	if !ok || got != 80 {
+		t.Errorf("got minutes viewed %v (exported %t), want 80", got, ok)
+	}
+}
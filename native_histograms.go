@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nativeHistogramBucketFactor bounds the growth between neighbouring native
+// histogram buckets, about 9% per bucket.
+const nativeHistogramBucketFactor = 1.1
+
+// Registered by registerAccountMetrics when -native_histograms is set.
+var cfBucketMinutesHistogram *accountWindowHistogram
+
+func registerNativeHistogramMetric() {
+	cfBucketMinutesHistogram = newAccountWindowHistogram(prometheus.HistogramOpts{
+		Name:                        "cloudflare_stream_bucket_minutes_viewed",
+		Help:                        "Distribution of the minutes viewed per -granularity bucket of the query window",
+		NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+	}, accountLabelNames())
+}
+
+// windowHistogram is a native histogram of the buckets in the current query
+// window. Every scrape queries the whole -lookback window again, so observing
+// each cycle into the same histogram would count the overlapping buckets over
+// and over. The histogram of a label set is rebuilt from the window instead,
+// its count and sum describe the window rather than a running total.
+type windowHistogram struct {
+	mu  sync.Mutex
+	vec *prometheus.HistogramVec
+}
+
+func (h *windowHistogram) Describe(ch chan<- *prometheus.Desc) {
+	h.vec.Describe(ch)
+}
+
+func (h *windowHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.vec.Collect(ch)
+}
+
+// observe replaces the histogram of the label set with one of values, so a
+// gather never sees it half rebuilt.
+func (h *windowHistogram) observe(labels prometheus.Labels, values []float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.vec.Delete(labels)
+	o := h.vec.With(labels)
+	for _, v := range values {
+		o.Observe(v)
+	}
+}
+
+func (h *windowHistogram) deletePartialMatch(labels prometheus.Labels) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.vec.DeletePartialMatch(labels)
+}
+
+// accountWindowHistogram splits the window histogram per account like
+// accountGaugeVec.
+type accountWindowHistogram struct {
+	opts   prometheus.HistogramOpts
+	labels []string
+
+	mu         sync.Mutex
+	histograms map[string]*windowHistogram
+}
+
+func newAccountWindowHistogram(opts prometheus.HistogramOpts, labelNames []string) *accountWindowHistogram {
+	return &accountWindowHistogram{opts: opts, labels: labelNames, histograms: map[string]*windowHistogram{}}
+}
+
+func (a *accountWindowHistogram) of(account monitoredAccount) *windowHistogram {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	h, ok := a.histograms[account.ID]
+	if !ok {
+		h = &windowHistogram{vec: prometheus.NewHistogramVec(a.opts, a.labels)}
+		tenants.register(account.ID, "viewed", h)
+		a.histograms[account.ID] = h
+	}
+	return h
+}
+
+func (a *accountWindowHistogram) observe(account monitoredAccount, labels prometheus.Labels, values []float64) {
+	a.of(account).observe(labels, values)
+}
+
+func (a *accountWindowHistogram) delete(account monitoredAccount, labels prometheus.Labels) {
+	a.of(account).deletePartialMatch(labels)
+}
+
+// bucketMinutes returns the minutes viewed of every -granularity bucket in
+// [start, end), summed over colos. Buckets cloudflare did not return had no
+// views and are 0, so the distribution covers the whole window.
+func bucketMinutes(rows []cfStreamMinutesViewedGroup, start, end time.Time) []float64 {
+	byBucket := map[time.Time]uint64{}
+	for _, r := range rows {
+		byBucket[r.Dimensions.Ts] += r.minutes()
+	}
+
+	values := make([]float64, 0, len(byBucket))
+	for _, minutes := range byBucket {
+		values = append(values, float64(minutes))
+	}
+	for n := int(end.Sub(start) / cfgGranularity); len(values) < n; {
+		values = append(values, 0)
+	}
+	return values
+}
+
+// observeBucketMinutes exports the distribution of the minutes viewed per
+// bucket of the account with -native_histograms.
+func observeBucketMinutes(account monitoredAccount, rows []cfStreamMinutesViewedGroup, start, end time.Time) {
+	if cfBucketMinutesHistogram == nil {
+		return
+	}
+	cfBucketMinutesHistogram.observe(account, accountLabels(account), bucketMinutes(rows, start, end))
+}
+
+func deleteBucketMinutes(account monitoredAccount) {
+	if cfBucketMinutesHistogram == nil {
+		return
+	}
+	cfBucketMinutesHistogram.delete(account, accountLabels(account))
+}
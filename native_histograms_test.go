@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// gatheredHistogram returns the histogram of the name in the tenant registry
+// of the test account.
+func gatheredHistogram(t *testing.T, name string) *dto.Histogram {
+	t.Helper()
+
+	families, err := tenants.account(testAccount().ID).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) == 1 {
+			return f.GetMetric()[0].GetHistogram()
+		}
+	}
+	return nil
+}
+
+func TestNativeHistograms(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		fixture   string
+		wantCount uint64
+		wantSum   float64
+		wantZeros uint64
+	}{
+		// 120, 90 and 30 minutes, and 3 buckets of the 30m window without views.
+		{"multiple buckets", true, "streaming_analytics.json", 6, 240, 3},
+		// The colos of a bucket are summed into one observation: 140 and 90.
+		{"buckets per colo", true, "streaming_analytics_colos.json", 6, 230, 4},
+		{"disabled", false, "streaming_analytics.json", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgNativeHistograms, tt.enabled)
+			setConfig(t, &cfgGroupByColo, tt.fixture == "streaming_analytics_colos.json")
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetExportedColos(t)
+			resetMetrics(t)
+			newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", tt.fixture))
+
+			// Each cycle rebuilds the histogram from the window, the
+			// overlapping buckets are not counted twice.
+			for i := 0; i < 2; i++ {
+				fetchStreamingAnalytics(context.Background(), testAccount())
+			}
+
+			h := gatheredHistogram(t, "cloudflare_stream_bucket_minutes_viewed")
+			if !tt.enabled {
+				if h != nil {
+					t.Errorf("got histogram %v without -native_histograms", h)
+				}
+				return
+			}
+			if h == nil {
+				t.Fatal("no cloudflare_stream_bucket_minutes_viewed histogram")
+			}
+			if h.GetSampleCount() != tt.wantCount || h.GetSampleSum() != tt.wantSum {
+				t.Errorf("got count %d and sum %v, want %d and %v", h.GetSampleCount(), h.GetSampleSum(), tt.wantCount, tt.wantSum)
+			}
+			if h.GetZeroCount() != tt.wantZeros {
+				t.Errorf("got %d observations in the zero bucket, want %d", h.GetZeroCount(), tt.wantZeros)
+			}
+			if len(h.GetBucket()) != 0 || h.GetSchema() != 3 {
+				t.Errorf("got %d classic buckets and schema %d, want native buckets of schema 3 only", len(h.GetBucket()), h.GetSchema())
+			}
+			// The native buckets are delta encoded.
+			var count, native int64
+			for _, d := range h.GetPositiveDelta() {
+				count += d
+				native += count
+			}
+			if want := int64(tt.wantCount - tt.wantZeros); native != want {
+				t.Errorf("got %d observations in the native buckets, want %d", native, want)
+			}
+		})
+	}
+}
+
+// TestNativeHistogramsExposition checks the native buckets reach scrapers
+// negotiating protobuf through the tenant path.
+func TestNativeHistogramsExposition(t *testing.T) {
+	setConfig(t, &cfgNativeHistograms, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &lastAccounts, &accountSet{})
+	lastAccounts.set([]monitoredAccount{testAccount()})
+	resetMetrics(t)
+	newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics/"+testAccount().ID, nil)
+	req.Header.Set("Accept", `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited`)
+	rec := httptest.NewRecorder()
+	tenantHandler("/metrics/", nil).ServeHTTP(rec, req)
+
+	dec := expfmt.NewDecoder(rec.Body, expfmt.ResponseFormat(rec.Header()))
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err != nil {
+			t.Fatalf("no native histogram served: %s", err)
+		}
+		if mf.GetName() == "cloudflare_stream_bucket_minutes_viewed" {
+			if h := mf.GetMetric()[0].GetHistogram(); len(h.GetPositiveSpan()) == 0 {
+				t.Errorf("got histogram %v, want native buckets", h)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strconv"
+)
+
+// jsonUint64 accepts both the number and the string encoding, cloudflare
+// occasionally quotes numeric fields and a plain uint64 would fail the whole
+// response. null leaves the value untouched like it does for a plain uint64.
+type jsonUint64 uint64
+
+func (n *jsonUint64) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return err
+		}
+		s = unquoted
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = jsonUint64(v)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONUint64(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *jsonUint64
+		wantErr bool
+	}{
+		{in: `{"minutesViewed": 42}`, want: uint64p(42)},
+		{in: `{"minutesViewed": "42"}`, want: uint64p(42)},
+		{in: `{"minutesViewed": 0}`, want: uint64p(0)},
+		{in: `{"minutesViewed": "18446744073709551615"}`, want: uint64p(18446744073709551615)},
+		{in: `{"minutesViewed": null}`},
+		{in: `{}`},
+		{in: `{"minutesViewed": "4\"2"}`, wantErr: true},
+		{in: `{"minutesViewed": "forty-two"}`, wantErr: true},
+		{in: `{"minutesViewed": -1}`, wantErr: true},
+		{in: `{"minutesViewed": 4.2}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got struct {
+			MinutesViewed *jsonUint64 `json:"minutesViewed"`
+		}
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if (got.MinutesViewed == nil) != (tt.want == nil) || (tt.want != nil && *got.MinutesViewed != *tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.in, got.MinutesViewed, tt.want)
+		}
+	}
+}
+
+func uint64p(v uint64) *jsonUint64 {
+	n := jsonUint64(v)
+	return &n
+}
+
+func TestQuotedMinutesViewed(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, mockFixture{method: "POST", path: "/graphql/", body: `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [
+		{"sum": {"minutesViewed": "42"}, "dimensions": {"ts": "2022-09-01T10:00:00Z"}},
+		{"sum": {"minutesViewed": 42}, "dimensions": {"ts": "2022-09-01T10:05:00Z"}}
+	]}]}}}`})
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(testAccount())); !ok || got != 42 {
+		t.Errorf("got minutes viewed %v (exported %t), want 42 from both encodings", got, ok)
+	}
+}
+
+func TestNullMinutesViewed(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics_nulls.json"))
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	// The null bucket counts neither as minutes nor as a bucket.
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{name: "cloudflare_streaming_minutes_viewed", want: 90},
+		{name: "cloudflare_stream_buckets_returned", want: 2},
+	}
+	for _, tt := range tests {
+		if got, ok := gatheredValue(t, tenants.all(), tt.name, accountLabels(testAccount())); !ok || got != tt.want {
+			t.Errorf("got %s %v (exported %t), want %v", tt.name, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case "prometheus", "influx", "json":
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q, expected prometheus, influx or json", format)
+	}
+}
+
+// runOneshot collects the metrics once, including the historical export when
+// -historical_window is set, and writes them to w in -output_format.
+func runOneshot(ctx context.Context, w io.Writer) error {
+	fetchMetrics(ctx)
+	if cfgHistoricalWindow > 0 {
+		if err := fetchHistoricalMetrics(ctx, cfgHistoricalWindow); err != nil {
+			return err
+		}
+	}
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	switch cfgOutputFormat {
+	case "influx":
+		return writeInflux(w, families, time.Now())
+	case "json":
+		return writeJSON(w, families)
+	}
+
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// requiredPermissions maps the scopes the exporter needs to the cloudflare
+// permission groups granting them.
+var requiredPermissions = map[string][]string{
+	"Stream:Read":    {"Stream Read", "Stream Write"},
+	"Analytics:Read": {"Account Analytics Read"},
+}
+
+// Registered by registerAccountMetrics once the token labels are known.
+var cfTokenHasPermission *prometheus.GaugeVec
+
+func registerPermissionMetric() {
+	names := []string{"permission"}
+	if len(apiTokens) > 1 {
+		names = append(names, "token_name")
+	}
+
+	cfTokenHasPermission = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_token_has_permission",
+		Help: "Whether the api token grants a permission required by the exporter",
+	}, names,
+	)
+}
+
+func fetchTokenPermissionGroups(ctx context.Context, token apiToken) ([]string, error) {
+	api, err := newAPIClient(token)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := api.VerifyAPIToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := api.GetAPIToken(ctx, verified.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return allowedPermissionGroups(details), nil
+}
+
+func allowedPermissionGroups(token cloudflare.APIToken) []string {
+	var groups []string
+	for _, p := range token.Policies {
+		if !strings.EqualFold(p.Effect, "allow") {
+			continue
+		}
+		for _, g := range p.PermissionGroups {
+			groups = append(groups, g.Name)
+		}
+	}
+	return groups
+}
+
+// checkTokenPermissions exposes which required permissions each token has
+// and warns about missing ones, which otherwise show up as silently empty
+// analytics. Tokens that may not read their own details are only logged.
+func checkTokenPermissions(ctx context.Context) {
+	for _, t := range apiTokens {
+		groups, err := fetchTokenPermissionGroups(ctx, t)
+		if err != nil {
+			log.Warnf("Could not read the permissions of token %s: %s", t.name, err)
+			continue
+		}
+
+		for permission, grantedBy := range requiredPermissions {
+			has := false
+			for _, g := range groups {
+				if contains(grantedBy, g) {
+					has = true
+					break
+				}
+			}
+
+			labels := prometheus.Labels{"permission": permission}
+			if len(apiTokens) > 1 {
+				labels["token_name"] = t.name
+			}
+			if has {
+				cfTokenHasPermission.With(labels).Set(1)
+				continue
+			}
+			cfTokenHasPermission.With(labels).Set(0)
+			log.Warnf("Token %s lacks the %s permission (%s), its analytics will be empty", t.name, permission, strings.Join(grantedBy, " or "))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheckTokenPermissions(t *testing.T) {
+	tokenPath := "/user/tokens/ed17574386854bf78a67040be0a770b0"
+	bothGranted := restFixture(http.MethodGet, tokenPath, "")
+	bothGranted.body = strings.Replace(readTestdata(t, "token_details_stream_only.json"), `"deny"`, `"allow"`, 1)
+
+	tests := []struct {
+		name    string
+		details mockFixture
+		want    map[string]float64
+	}{
+		{
+			// The analytics group is only listed by a deny policy.
+			name:    "lacks analytics",
+			details: restFixture(http.MethodGet, tokenPath, "token_details_stream_only.json"),
+			want:    map[string]float64{"Stream:Read": 1, "Analytics:Read": 0},
+		},
+		{
+			name:    "has both",
+			details: bothGranted,
+			want:    map[string]float64{"Stream:Read": 1, "Analytics:Read": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, restFixture(http.MethodGet, "/user/tokens/verify", "token_verify.json"), tt.details)
+
+			checkTokenPermissions(context.Background())
+
+			for permission, want := range tt.want {
+				if got := testutil.ToFloat64(cfTokenHasPermission.With(prometheus.Labels{"permission": permission})); got != want {
+					t.Errorf("got cloudflare_stream_token_has_permission{permission=%q} %v, want %v", permission, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTokenPermissionsUnreadable(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	denied := restFixture(http.MethodGet, "/user/tokens/verify", "rest_error.json")
+	denied.status = http.StatusForbidden
+	newMockCloudflare(t, denied)
+
+	checkTokenPermissions(context.Background())
+
+	if got := testutil.CollectAndCount(cfTokenHasPermission); got != 0 {
+		t.Errorf("got %d permission series for a token that cannot read its details, want none", got)
+	}
+}
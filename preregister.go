@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// placeholders holds the accounts still exposing the zero series set by
+// -preregister_metrics, keyed by accountKey.
+var placeholders = struct {
+	sync.Mutex
+	accounts map[string]bool
+}{accounts: map[string]bool{}}
+
+// preregisterMetrics exposes a zero minutes viewed series for every monitored
+// account before the first scrape, so the metric exists right after a
+// restart. Without the account list nothing is preregistered.
+func preregisterMetrics(ctx context.Context) {
+	accounts, err := monitoredAccounts(ctx)
+	if err != nil {
+		log.Warnf("Not preregistering metrics: %s", err)
+		return
+	}
+
+	placeholders.Lock()
+	defer placeholders.Unlock()
+	for _, a := range accounts {
+		setMinutesViewed(a, 0)
+		placeholders.accounts[accountKey(a)] = true
+	}
+	log.Infof("Preregistered minutes viewed for %d accounts", len(accounts))
+}
+
+// dropPlaceholder removes the zero series of the account once real data
+// arrives. Only colo grouping needs it, the placeholder has no colo and
+// would otherwise linger beside the per colo series.
+func dropPlaceholder(account monitoredAccount) {
+	placeholders.Lock()
+	defer placeholders.Unlock()
+
+	key := accountKey(account)
+	if !placeholders.accounts[key] {
+		return
+	}
+	delete(placeholders.accounts, key)
+	if cfgGroupByColo {
+		deleteMinutesViewed(account)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestPreregisterMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		byColo bool
+		file   string
+		// series of the fetched account once real data arrived.
+		series int
+	}{
+		{"without colos", false, "streaming_analytics.json", 1},
+		// The colo-less placeholder is dropped beside the per colo series.
+		{"by colo", true, "streaming_analytics_colos.json", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgGroupByColo, tt.byColo)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			setConfig(t, &placeholders.accounts, map[string]bool{})
+			newMockCloudflare(t,
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				graphqlFixture("StreamMinutesViewed", tt.file),
+			)
+
+			preregisterMetrics(context.Background())
+
+			// Before any fetch, every account has a zero series.
+			if got := countSeries(t, gatherer, "cloudflare_streaming_minutes_viewed"); got != 2 {
+				t.Fatalf("got %d minutes viewed series before the first fetch, want one per account", got)
+			}
+			if got, ok := gatheredValue(t, gatherer, "cloudflare_streaming_minutes_viewed", viewedLabels(testAccount(), "")); !ok || got != 0 {
+				t.Errorf("got placeholder %v (exported %t), want 0", got, ok)
+			}
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			if got := countSeries(t, tenants.account(testAccount().ID), "cloudflare_streaming_minutes_viewed"); got != tt.series {
+				t.Errorf("got %d series of the fetched account, want %d", got, tt.series)
+			}
+			if got := countSeries(t, gatherer, "cloudflare_streaming_minutes_viewed"); got != tt.series+1 {
+				t.Errorf("got %d series in total, want the placeholder of the other account kept", got)
+			}
+		})
+	}
+}
+
+func TestPreregisterMetricsWithoutAccounts(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	setConfig(t, &placeholders.accounts, map[string]bool{})
+	newMockCloudflare(t, mockFixture{method: http.MethodGet, path: "/client/v4/accounts", status: http.StatusForbidden, file: "rest_error.json"})
+
+	preregisterMetrics(context.Background())
+
+	if got := countSeries(t, gatherer, "cloudflare_streaming_minutes_viewed"); got != 0 {
+		t.Errorf("got %d minutes viewed series, want none when the accounts cannot be listed", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	log "github.com/sirupsen/logrus"
+)
+
+func pushMetrics(ctx context.Context) error {
+	return push.New(cfgPushgatewayURL, cfgPushgatewayJob).Gatherer(gatherer).PushContext(ctx)
+}
+
+// scrapeMu serializes the scrape cycles, which share cycleSummary and the
+// series limit, and bounds the api calls /-/refresh can cause.
+var scrapeMu sync.Mutex
+
+// scrapeAndPush runs one scrape cycle once the running one, if any, is done.
+func scrapeAndPush(ctx context.Context) {
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+
+	runScrapeAndPush(ctx)
+}
+
+// tryScrapeAndPush runs one scrape cycle unless one is already running, and
+// reports whether it did.
+func tryScrapeAndPush(ctx context.Context) bool {
+	if !scrapeMu.TryLock() {
+		return false
+	}
+	defer scrapeMu.Unlock()
+
+	runScrapeAndPush(ctx)
+	return true
+}
+
+// runScrapeAndPush runs one scrape cycle, writes the result to -metrics_file
+// and pushes it when -remote_write_url or -pushgateway_url is set.
+func runScrapeAndPush(ctx context.Context) {
+	defer recoverScrapePanic()
+
+	if scheduledOff(time.Now()) {
+		log.Debug("Skipping scrape outside of -scrape_schedule")
+		return
+	}
+
+	fetchMetrics(ctx)
+	if cfgRequireAllAccountsHaveData {
+		firstScrapeAudit.run(lastAccounts.all())
+	}
+	if len(cfgMetricsFile) > 0 {
+		if err := writeMetricsFile(cfgMetricsFile); err != nil {
+			log.Errorf("Writing metrics to %s: %s", cfgMetricsFile, err)
+		}
+	}
+	if len(cfgRemoteWriteURL) > 0 {
+		if err := remoteWrite(ctx, cfgRemoteWriteURL); err != nil {
+			log.Errorf("Remote writing metrics: %s", err)
+		}
+	}
+	if len(cfgPushgatewayURL) == 0 {
+		return
+	}
+
+	if err := pushMetrics(ctx); err != nil {
+		log.Errorf("Pushing metrics to %s: %s", cfgPushgatewayURL, err)
+	}
+}
+
+// drainToPushgateway runs a final scrape and push so the window since the
+// last cycle is not lost when the process is terminated. The scrape is
+// cancelled once timeout is over.
+func drainToPushgateway(timeout time.Duration) {
+	if len(cfgPushgatewayURL) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("Running final scrape before shutdown")
+		scrapeAndPush(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("Final scrape did not finish within the shutdown timeout")
+	}
+}
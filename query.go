@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultQueryWindow = 30 * time.Minute
+	// Cloudflare does not serve adaptive groups further back than this.
+	maxQueryWindow = 30 * 24 * time.Hour
+)
+
+type queryResult struct {
+	AccountID   string                        `json:"account_id"`
+	AccountName string                        `json:"account_name"`
+	Window      string                        `json:"window"`
+	Result      *cfResponseStreamingAnalytics `json:"result,omitempty"`
+	Error       string                        `json:"error,omitempty"`
+}
+
+func parseQueryWindow(raw string) (time.Duration, error) {
+	if len(raw) == 0 {
+		return defaultQueryWindow, nil
+	}
+
+	window, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window %q: %w", raw, err)
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("window must be positive, got %s", window)
+	}
+	if window > maxQueryWindow {
+		log.Warnf("Requested window %s exceeds retention, capping to %s", window, maxQueryWindow)
+		window = maxQueryWindow
+	}
+
+	return window, nil
+}
+
+// queryHandler runs a one-off streaming analytics query for ad-hoc debugging
+// and returns the raw result as JSON instead of the prometheus format.
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	window, err := parseQueryWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	accountID := r.URL.Query().Get("account")
+
+	accounts, err := monitoredAccounts(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	results := []queryResult{}
+	for _, a := range accounts {
+		if len(accountID) > 0 && a.ID != accountID {
+			continue
+		}
+
+		result := queryResult{AccountID: a.ID, AccountName: accountDisplayName(a), Window: window.String()}
+		start, end := queryWindow(window)
+		resp, err := fetchStreamingTotals(r.Context(), a, start, end)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Result = resp
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseQueryWindow(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{raw: "", want: defaultQueryWindow},
+		{raw: "1h", want: time.Hour},
+		{raw: "24h", want: 24 * time.Hour},
+		{raw: "2000h", want: maxQueryWindow},
+		{raw: "0s", wantErr: true},
+		{raw: "-1h", wantErr: true},
+		{raw: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseQueryWindow(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQueryWindow(%q) error = %v, want error %t", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseQueryWindow(%q) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestQueryHandler(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &accountAliases, map[string]string{testAccount().ID: "acme-prod"})
+	resetMetrics(t)
+	m := newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	rec := httptest.NewRecorder()
+	queryHandler(rec, httptest.NewRequest(http.MethodGet, "/query?window=1h&account="+testAccount().ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+
+	var results []queryResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].AccountID != testAccount().ID || results[0].Window != "1h0m0s" {
+		t.Fatalf("got results %+v, want the 1h window of %s", results, testAccount().ID)
+	}
+	if results[0].AccountName != "acme-prod" {
+		t.Errorf("got account name %q, want the alias used in the metric labels", results[0].AccountName)
+	}
+	if results[0].Result == nil || totalMinutes(results[0].Result.Viewer.Accounts[0].AccountStreamMinutesViewedAdaptiveGroupsSum) != 240 {
+		t.Errorf("got result %+v, want the 240 minutes of the fixture", results[0].Result)
+	}
+
+	requests := m.requests("/graphql/")
+	if len(requests) != 1 {
+		t.Fatalf("got %d graphql requests, want 1", len(requests))
+	}
+	mintime, _ := time.Parse(time.RFC3339Nano, requests[0].variables["mintime"].(string))
+	maxtime, _ := time.Parse(time.RFC3339Nano, requests[0].variables["maxtime"].(string))
+	if got := maxtime.Sub(mintime); got != time.Hour {
+		t.Errorf("queried a %s window, want 1h", got)
+	}
+}
+
+func TestQueryHandlerBadWindow(t *testing.T) {
+	rec := httptest.NewRecorder()
+	queryHandler(rec, httptest.NewRequest(http.MethodGet, "/query?window=soon", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", rec.Code)
+	}
+}
+
+func TestQueryDebugOnly(t *testing.T) {
+	tests := []struct {
+		enabled bool
+		status  int
+	}{
+		{true, http.StatusOK},
+		{false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		setConfig(t, &cfgEnableDebugEndpoints, tt.enabled)
+		setConfig(t, &http.DefaultServeMux, http.NewServeMux())
+		setConfig(t, &apiTokens, []apiToken{testAccount().token})
+		resetMetrics(t)
+		m := newMockCloudflare(t,
+			restFixture(http.MethodGet, "/accounts", "accounts.json"),
+			graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+		)
+		registerRoutes([]string{"/metrics"}, nil)
+
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query?account="+testAccount().ID, nil))
+		if rec.Code != tt.status {
+			t.Errorf("debug endpoints enabled %t: got status %d, want %d", tt.enabled, rec.Code, tt.status)
+		}
+		if !tt.enabled && len(m.requests("/graphql/")) > 0 {
+			t.Error("queried graphql with the debug endpoints disabled")
+		}
+	}
+}
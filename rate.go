@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registered by registerAccountMetrics once the account labels are known.
+var cfMinutesViewedPerMinute *accountGaugeVec
+
+func registerRateMetric() {
+	cfMinutesViewedPerMinute = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_minutes_viewed_per_minute",
+		Help: "Minutes viewed per minute of wall-clock time over the query window",
+	}, accountLabelNames(),
+	)
+}
+
+// bucketOverlap returns how much of the bucket starting at ts lies within
+// [start, end), the edge buckets of a window are usually only partly covered.
+func bucketOverlap(ts, start, end time.Time) time.Duration {
+	from, to := ts, ts.Add(cfgGranularity)
+	if from.Before(start) {
+		from = start
+	}
+	if to.After(end) {
+		to = end
+	}
+	if !to.After(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// minutesViewedPerMinute is the minutes viewed in the buckets overlapping
+// [start, end) over the minutes of the window. Cloudflare only counts the
+// views inside the queried window, so an edge bucket is added in full. Buckets
+// without views are not returned by cloudflare but still count for their
+// duration, so the rate is over the whole window rather than the buckets.
+func minutesViewedPerMinute(rows []cfStreamMinutesViewedGroup, start, end time.Time) float64 {
+	window := end.Sub(start)
+	if window <= 0 {
+		return 0
+	}
+
+	var minutes float64
+	for _, r := range rows {
+		if bucketOverlap(r.Dimensions.Ts, start, end) == 0 {
+			continue
+		}
+		minutes += float64(r.minutes())
+	}
+
+	return minutes / window.Minutes()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMinutesViewedPerMinute(t *testing.T) {
+	setConfig(t, &cfgGranularity, 5*time.Minute)
+	at := func(clock string) time.Time {
+		ts, err := time.Parse(time.RFC3339, "2022-09-01T"+clock+":00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	row := func(clock string, minutes uint64) cfStreamMinutesViewedGroup {
+		var r cfStreamMinutesViewedGroup
+		v := jsonUint64(minutes)
+		r.Sum.MinutesViewed = &v
+		r.Dimensions.Ts = at(clock)
+		return r
+	}
+
+	tests := []struct {
+		name       string
+		rows       []cfStreamMinutesViewedGroup
+		start, end string
+		want       float64
+	}{
+		{"whole buckets", []cfStreamMinutesViewedGroup{row("10:00", 100), row("10:05", 50)}, "10:00", "10:10", 15},
+		// Cloudflare only returns the minutes viewed inside the window, so a
+		// partly covered edge bucket counts in full: 170 minutes over 13.
+		{"partial first bucket", []cfStreamMinutesViewedGroup{row("10:00", 100), row("10:05", 50), row("10:10", 20)}, "10:02", "10:15", 170.0 / 13},
+		// 100 minutes over the 6 minutes of the window.
+		{"partial last bucket", []cfStreamMinutesViewedGroup{row("10:05", 50), row("10:10", 50)}, "10:05", "10:11", 100.0 / 6},
+		// The bucket without views still counts for its 5 minutes.
+		{"missing bucket", []cfStreamMinutesViewedGroup{row("10:00", 150)}, "10:00", "10:10", 15},
+		{"bucket outside the window", []cfStreamMinutesViewedGroup{row("09:50", 500), row("10:00", 50)}, "10:00", "10:05", 10},
+		{"empty window", []cfStreamMinutesViewedGroup{row("10:00", 50)}, "10:00", "10:00", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := minutesViewedPerMinute(tt.rows, at(tt.start), at(tt.end))
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
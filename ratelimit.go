@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var cfRateLimitUsedRatio = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "cloudflare_stream_rate_limit_used_ratio",
+	Help: "Share of the cloudflare api rate limit used, from the rate limit headers of the last response that carried them",
+})
+
+// rateLimitUsage returns the limit and remaining requests advertised by the
+// response, from the Ratelimit and Ratelimit-Policy headers or the older
+// X-RateLimit-Limit and X-RateLimit-Remaining.
+func rateLimitUsage(h http.Header) (limit, remaining float64, ok bool) {
+	if policy, state := h.Get("Ratelimit-Policy"), h.Get("Ratelimit"); len(policy) > 0 && len(state) > 0 {
+		q, qok := rateLimitParam(policy, "q")
+		r, rok := rateLimitParam(state, "r")
+		if qok && rok {
+			return q, r, true
+		}
+	}
+
+	l, err := strconv.ParseFloat(h.Get("X-RateLimit-Limit"), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	r, err := strconv.ParseFloat(h.Get("X-RateLimit-Remaining"), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return l, r, true
+}
+
+// rateLimitParam reads a parameter of a structured rate limit header such as
+// `"default";r=50;t=30`.
+func rateLimitParam(header, name string) (float64, bool) {
+	for _, part := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key != name {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		return v, err == nil
+	}
+	return 0, false
+}
+
+func recordRateLimit(resp *http.Response) {
+	limit, remaining, ok := rateLimitUsage(resp.Header)
+	if !ok || limit <= 0 {
+		return
+	}
+	cfRateLimitUsedRatio.Set((limit - remaining) / limit)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitUsedRatio(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   float64
+	}{
+		{"x-ratelimit headers", http.Header{"X-Ratelimit-Limit": {"1200"}, "X-Ratelimit-Remaining": {"300"}}, 0.75},
+		{"structured headers", http.Header{"Ratelimit-Policy": {`"default";q=1200;w=300`}, "Ratelimit": {`"default";r=900;t=30`}}, 0.25},
+		{"structured headers win", http.Header{"Ratelimit-Policy": {`"default";q=100;w=300`}, "Ratelimit": {`"default";r=0;t=30`}, "X-Ratelimit-Limit": {"100"}, "X-Ratelimit-Remaining": {"100"}}, 1},
+		// The gauge keeps the last value when a response has no usable headers.
+		{"no headers", nil, 0.5},
+		{"zero limit", http.Header{"X-Ratelimit-Limit": {"0"}, "X-Ratelimit-Remaining": {"0"}}, 0.5},
+		{"unparseable", http.Header{"X-Ratelimit-Limit": {"many"}, "X-Ratelimit-Remaining": {"10"}}, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMetrics(t)
+			accounts := restFixture(http.MethodGet, "/accounts", "accounts.json")
+			accounts.header = tt.header
+			newMockCloudflare(t, accounts)
+			cfRateLimitUsedRatio.Set(0.5)
+
+			if _, err := fetchAccounts(context.Background(), testAccount().token); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := testutil.ToFloat64(cfRateLimitUsedRatio); got != tt.want {
+				t.Errorf("got rate limit used ratio %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
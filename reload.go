@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serve starts an http server for the default mux on a new listener.
+func serve(network, addr string) (*http.Server, error) {
+	listener, err := newListener(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Addr: addr}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error(err)
+		}
+	}()
+
+	return server, nil
+}
+
+// reloadListener re-reads -listen and -listen_network from -config_source and
+// moves the http server to the new address, draining the old one within
+// timeout. The old server is kept when the new address cannot be bound. Other
+// settings still require a restart.
+func reloadListener(server *http.Server, timeout time.Duration) *http.Server {
+	if len(cfgConfigSource) == 0 {
+		log.Warn("Received reload without -config_source, nothing to reload")
+		return server
+	}
+
+	source, err := newConfigSource(cfgConfigSource)
+	if err != nil {
+		log.Errorf("Reloading: %s", err)
+		return server
+	}
+	values, err := source.Load(context.Background())
+	if err != nil {
+		log.Errorf("Reloading: %s", err)
+		return server
+	}
+
+	network, addr := cfgListenNetwork, cfgListen
+	if v, ok := values["listen_network"]; ok {
+		network = v
+	}
+	if v, ok := values["listen"]; ok {
+		addr = v
+	}
+	if network == cfgListenNetwork && addr == cfgListen {
+		log.Info("Reloaded, listen address unchanged")
+		return server
+	}
+
+	next, err := serve(network, addr)
+	if err != nil {
+		log.Warnf("Keeping listener on %s, binding %s (%s) failed: %s", cfgListen, addr, network, err)
+		return server
+	}
+	log.Infof("Moved listener from %s to %s (%s)", cfgListen, addr, network)
+	cfgListen, cfgListenNetwork = addr, network
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Draining previous listener: %s", err)
+		}
+	}()
+
+	return next
+}
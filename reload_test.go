@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// serves reports whether an http server answers on addr.
+func serves(addr string) bool {
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Get("http://" + addr + "/")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+func TestReloadListener(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	old, next := freeAddr(t), freeAddr(t)
+	tests := []struct {
+		name      string
+		listen    string
+		wantMoved bool
+	}{
+		{"new address", next, true},
+		{"unchanged address", old, false},
+		{"address in use", busy.Addr().String(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgListen, old)
+			setConfig(t, &cfgListenNetwork, "tcp")
+			setConfig(t, &cfgConfigSource, "consul")
+			setConfig(t, &cfgConsulAddr, stubConsul(t, map[string]string{"/v1/kv/exporter": "listen " + tt.listen + "\n"}))
+			setConfig(t, &cfgConsulKey, "exporter")
+
+			server, err := serve("tcp", old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reloaded := reloadListener(server, time.Second)
+			defer reloaded.Close()
+			defer server.Close()
+
+			if moved := reloaded != server; moved != tt.wantMoved {
+				t.Fatalf("moved listener = %t, want %t", moved, tt.wantMoved)
+			}
+			if !tt.wantMoved {
+				if cfgListen != old || !serves(old) {
+					t.Errorf("got -listen %s, want the server kept on %s", cfgListen, old)
+				}
+				return
+			}
+			if cfgListen != tt.listen || !serves(tt.listen) {
+				t.Errorf("got -listen %s, want the server moved to %s", cfgListen, tt.listen)
+			}
+			// The old listener is drained in the background.
+			deadline := time.Now().Add(2 * time.Second)
+			for serves(old) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if serves(old) {
+				t.Errorf("old address %s still serves after the reload", old)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// remoteAllowlist holds the account IDs last fetched from
+// -include_accounts_url, which add to -include_accounts.
+type remoteAllowlist struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+var remoteIncludes = &remoteAllowlist{}
+
+func (l *remoteAllowlist) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.ids
+}
+
+// refresh fetches the list again, keeping the last good one when that fails.
+func (l *remoteAllowlist) refresh(ctx context.Context, url string) error {
+	ids, err := fetchAllowlist(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Loaded %d accounts from -include_accounts_url", len(ids))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ids = ids
+	return nil
+}
+
+// fetchAllowlist reads account IDs separated by newlines or commas, lines
+// starting with # are comments.
+func fetchAllowlist(ctx context.Context, url string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		ids = append(ids, splitList(line)...)
+	}
+
+	return ids, nil
+}
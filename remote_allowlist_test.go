@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestIncludeAccountsURL(t *testing.T) {
+	var mu sync.Mutex
+	status, list := http.StatusOK, ""
+	allowlist := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, list)
+	}))
+	defer allowlist.Close()
+
+	setConfig(t, &cfgIncludeAccountsURL, allowlist.URL)
+	setConfig(t, &remoteIncludes, &remoteAllowlist{})
+	setConfig(t, &lastAccounts, &accountSet{})
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	cycles := []struct {
+		name   string
+		status int
+		list   string
+		want   []string
+	}{
+		{"never loaded", http.StatusInternalServerError, "", nil},
+		{"empty list", http.StatusOK, "# nothing yet\n", nil},
+		{"newline list", http.StatusOK, "# streaming only\n" + testAccount().ID + "\n", []string{testAccount().ID}},
+		{"fetch failure keeps the last list", http.StatusInternalServerError, "", []string{testAccount().ID}},
+		{"comma list", http.StatusOK, testAccount().ID + ", " + staging, []string{testAccount().ID, staging}},
+	}
+	for _, cycle := range cycles {
+		mu.Lock()
+		status, list = cycle.status, cycle.list
+		mu.Unlock()
+
+		fetchMetrics(context.Background())
+
+		if got := accountIDs(lastAccounts.all()); !equalStrings(got, cycle.want) {
+			t.Errorf("%s: monitored %v, want %v", cycle.name, got, cycle.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type remoteWriteLabel struct {
+	name, value string
+}
+
+type remoteWriteSeries struct {
+	labels []remoteWriteLabel
+	value  float64
+	ts     int64
+}
+
+// remoteWriteSeriesOf flattens a family into remote write series the way the
+// text exposition does, histograms and summaries become their _bucket or
+// quantile, _sum and _count series.
+func remoteWriteSeriesOf(mf *dto.MetricFamily, now time.Time) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for _, m := range mf.GetMetric() {
+		ts := now.UnixMilli()
+		if m.TimestampMs != nil {
+			ts = m.GetTimestampMs()
+		}
+
+		add := func(name string, value float64, extra ...remoteWriteLabel) {
+			labels := []remoteWriteLabel{{"__name__", name}}
+			for _, l := range m.GetLabel() {
+				labels = append(labels, remoteWriteLabel{l.GetName(), l.GetValue()})
+			}
+			labels = append(labels, extra...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+			series = append(series, remoteWriteSeries{labels: labels, value: value, ts: ts})
+		}
+
+		name := mf.GetName()
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			add(name, m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			add(name, m.GetGauge().GetValue())
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			for _, q := range s.GetQuantile() {
+				add(name, q.GetValue(), remoteWriteLabel{"quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)})
+			}
+			add(name+"_sum", s.GetSampleSum())
+			add(name+"_count", float64(s.GetSampleCount()))
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			for _, b := range h.GetBucket() {
+				add(name+"_bucket", float64(b.GetCumulativeCount()), remoteWriteLabel{"le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)})
+			}
+			add(name+"_bucket", float64(h.GetSampleCount()), remoteWriteLabel{"le", "+Inf"})
+			add(name+"_sum", h.GetSampleSum())
+			add(name+"_count", float64(h.GetSampleCount()))
+		default:
+			add(name, m.GetUntyped().GetValue())
+		}
+	}
+	return series
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest by hand, it only
+// needs the timeseries, labels and samples fields.
+func encodeWriteRequest(series []remoteWriteSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.ts))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// remoteWrite sends the current metrics to -remote_write_url.
+func remoteWrite(ctx context.Context, url string) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var series []remoteWriteSeries
+	for _, mf := range families {
+		series = append(series, remoteWriteSeriesOf(mf, now)...)
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote write to %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes the fields of a prometheus.WriteRequest that
+// encodeWriteRequest writes.
+func decodeWriteRequest(t *testing.T, b []byte) []remoteWriteSeries {
+	t.Helper()
+
+	// fields calls fn with the number and raw value of each field of b.
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("invalid tag: %s", protowire.ParseError(n))
+			}
+			b = b[n:]
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				t.Fatalf("invalid field %d: %s", num, protowire.ParseError(m))
+			}
+			fn(num, typ, b[:m])
+			b = b[m:]
+		}
+	}
+	bytesOf := func(v []byte) []byte {
+		b, _ := protowire.ConsumeBytes(v)
+		return b
+	}
+
+	var series []remoteWriteSeries
+	fields(b, func(_ protowire.Number, _ protowire.Type, v []byte) {
+		var s remoteWriteSeries
+		fields(bytesOf(v), func(num protowire.Number, _ protowire.Type, v []byte) {
+			switch num {
+			case 1:
+				var l remoteWriteLabel
+				fields(bytesOf(v), func(num protowire.Number, _ protowire.Type, v []byte) {
+					if num == 1 {
+						l.name = string(bytesOf(v))
+					} else {
+						l.value = string(bytesOf(v))
+					}
+				})
+				s.labels = append(s.labels, l)
+			case 2:
+				fields(bytesOf(v), func(num protowire.Number, _ protowire.Type, v []byte) {
+					if num == 1 {
+						bits, _ := protowire.ConsumeFixed64(v)
+						s.value = math.Float64frombits(bits)
+					} else {
+						ts, _ := protowire.ConsumeVarint(v)
+						s.ts = int64(ts)
+					}
+				})
+			}
+		})
+		series = append(series, s)
+	})
+	return series
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var received []remoteWriteSeries
+	var header http.Header
+	status := http.StatusNoContent
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("body is not snappy encoded: %s", err)
+		}
+		received = decodeWriteRequest(t, body)
+		w.WriteHeader(status)
+	}))
+	defer stub.Close()
+
+	resetMetrics(t)
+	setMinutesViewed(testAccount(), 80)
+
+	if err := remoteWrite(context.Background(), stub.URL); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"Content-Encoding": "snappy", "Content-Type": "application/x-protobuf", "X-Prometheus-Remote-Write-Version": "0.1.0"} {
+		if got := header.Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+
+	want := []remoteWriteLabel{{"__name__", "cloudflare_streaming_minutes_viewed"}, {"account", "Acme Streaming"}, {"account_id", testAccount().ID}}
+	found := false
+	for _, s := range received {
+		if len(s.labels) == len(want) && s.labels[0] == want[0] {
+			found = true
+			for i := range want {
+				if s.labels[i] != want[i] {
+					t.Errorf("got labels %v, want %v sorted by name", s.labels, want)
+				}
+			}
+			if s.value != 80 || s.ts <= 0 {
+				t.Errorf("got sample %v at %d, want 80 with a timestamp", s.value, s.ts)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no minutes viewed series in %d written series", len(received))
+	}
+
+	status = http.StatusBadRequest
+	if err := remoteWrite(context.Background(), stub.URL); err == nil {
+		t.Error("remoteWrite() ignored a 400 from the receiver")
+	}
+}
+
+func TestRemoteWriteSeriesOfHistogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Help: "help", Buckets: []float64{0.5, 1}})
+	reg.MustRegister(h)
+	h.Observe(0.2)
+	h.Observe(2)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, s := range remoteWriteSeriesOf(families[0], time.Unix(0, 0)) {
+		var le string
+		for _, l := range s.labels {
+			if l.name == "le" {
+				le = l.value
+			}
+		}
+		got = append(got, fmt.Sprintf("%s{le=%q} %v", s.labels[0].value, le, s.value))
+	}
+	want := []string{
+		`duration_seconds_bucket{le="0.5"} 1`,
+		`duration_seconds_bucket{le="1"} 1`,
+		`duration_seconds_bucket{le="+Inf"} 2`,
+		`duration_seconds_sum{le=""} 2.2`,
+		`duration_seconds_count{le=""} 2`,
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var cfRateLimitWait = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "cloudflare_stream_rate_limit_wait_seconds_total",
+	Help: "Time rest requests spent waiting for -cf_rate_limit, growing steadily means the limit is too low for the accounts and interval",
+})
+
+// restLimiters rate limits the rest requests per token to -cf_rate_limit.
+// cloudflare-go limits on its own out of reach of any instrumentation, so its
+// limiter is lifted in apiClientOptions and requests wait here instead.
+type restLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+var restLimit = &restLimiters{limiters: map[string]*rate.Limiter{}}
+
+// wait blocks until the token of req may send another request, like the
+// cloudflare-go limiter without bursts. A wait that would outlast the request
+// deadline fails right away with context.DeadlineExceeded.
+func (l *restLimiters) wait(req *http.Request) error {
+	key := req.Header.Get("Authorization")
+	l.mu.Lock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(cfgCfRateLimit), 1)
+		l.limiters[key] = limiter
+	}
+	l.mu.Unlock()
+
+	start := time.Now()
+	err := limiter.Wait(req.Context())
+	cfRateLimitWait.Add(time.Since(start).Seconds())
+	if err != nil && req.Context().Err() == nil {
+		return fmt.Errorf("waiting for -cf_rate_limit: %w", context.DeadlineExceeded)
+	}
+	return err
+}
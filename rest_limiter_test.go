@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    float64
+		tokens   []string
+		min, max float64
+	}{
+		// Without bursts the 2nd and 3rd request of a token wait 200ms each.
+		{"throttled", 5, []string{"a", "a", "a"}, 0.3, 0.6},
+		{"tokens limited apart", 5, []string{"a", "b", "c"}, 0, 0.1},
+		{"under the limit", 1000, []string{"a", "a", "a"}, 0, 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgCfRateLimit, tt.limit)
+			setConfig(t, &restLimit, &restLimiters{limiters: map[string]*rate.Limiter{}})
+			before := testutil.ToFloat64(cfRateLimitWait)
+
+			for _, token := range tt.tokens {
+				req := httptest.NewRequest(http.MethodGet, "https://api.cloudflare.com/client/v4/accounts", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				if err := restLimit.wait(req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := testutil.ToFloat64(cfRateLimitWait) - before; got < tt.min || got > tt.max {
+				t.Errorf("got %.3fs waited, want between %vs and %vs", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestCfRateLimit(t *testing.T) {
+	resetMetrics(t)
+	m := newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"))
+	setConfig(t, &cfgCfRateLimit, 10.0)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := fetchAccounts(context.Background(), testAccount().token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request uses the burst, the other 3 wait 100ms each.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("4 requests took %v, want them spread by -cf_rate_limit", elapsed)
+	}
+	if got := len(m.requests("/client/v4/accounts")); got != 4 {
+		t.Errorf("got %d requests, want 4", got)
+	}
+}
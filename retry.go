@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// http date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		d := date.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// maxRetryDelay caps the exponential backoff like the rest client's policy,
+// unless -retry_backoff itself is longer.
+const maxRetryDelay = maxRESTRetryDelaySeconds * time.Second
+
+// retryJitter picks the wait in [0, n), replaced by tests for exact waits.
+var retryJitter = rand.Int63n
+
+// retryDelay honours the Retry-After of a 429 exactly and otherwise backs off
+// exponentially from -retry_backoff up to maxRetryDelay. The backoff is fully
+// jittered so that accounts failing together do not retry in lockstep.
+func retryDelay(resp *http.Response, attempt int, now time.Time) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			return d
+		}
+	}
+
+	return time.Duration(retryJitter(int64(backoffCeiling(attempt)) + 1))
+}
+
+// backoffCeiling is -retry_backoff doubled attempt times, without overflowing
+// past maxRetryDelay.
+func backoffCeiling(attempt int) time.Duration {
+	if cfgRetryBackoff <= 0 {
+		return 0
+	}
+	if cfgRetryBackoff >= maxRetryDelay {
+		return cfgRetryBackoff
+	}
+	if attempt >= 63 || cfgRetryBackoff > maxRetryDelay>>attempt {
+		return maxRetryDelay
+	}
+	return cfgRetryBackoff << attempt
+}
+
+// roundTripWithRetry retries failed requests up to -max_retries times. A wait
+// that would not fit before the request deadline or past -max_retry_duration
+// is not started, the last response is returned instead.
+func (t *apiTransport) roundTripWithRetry(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTripEndpoints(req)
+		if attempt >= cfgMaxRetries || !shouldRetry(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		wait := retryDelay(resp, attempt, time.Now())
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+		if cfgMaxRetryDuration > 0 && time.Since(start)+wait > cfgMaxRetryDuration {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"7", 7 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Thu, 01 Sep 2022 10:00:30 GMT", 30 * time.Second, true},
+		{"Thu, 01 Sep 2022 09:59:00 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %t, want %v, %t", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryAfter429(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		timeout    time.Duration
+		requests   int
+		minWait    time.Duration
+		maxWait    time.Duration
+	}{
+		// -retry_backoff is a minute, a retry within seconds honoured the header.
+		{"waits for Retry-After", "1", 10 * time.Second, 2, time.Second, 5 * time.Second},
+		{"Retry-After past the deadline", "30", 2 * time.Second, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgRetryBackoff, time.Minute)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			limited := graphqlFixture("StreamMinutesViewed", "")
+			limited.status = http.StatusTooManyRequests
+			limited.header = http.Header{"Retry-After": {tt.retryAfter}}
+			limited.body = "rate limited"
+			m := newMockCloudflare(t, limited, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			fetchStreamingAnalytics(ctx, testAccount())
+
+			requests := m.requests("/graphql/")
+			if len(requests) != tt.requests {
+				t.Fatalf("got %d graphql requests, want %d", len(requests), tt.requests)
+			}
+			if tt.requests == 1 {
+				if elapsed := time.Since(start); elapsed > time.Second {
+					t.Errorf("gave up after %v, want no wait past the deadline", elapsed)
+				}
+				return
+			}
+			if wait := requests[1].at.Sub(requests[0].at); wait < tt.minWait || wait > tt.maxWait {
+				t.Errorf("retried after %v, want between %v and %v", wait, tt.minWait, tt.maxWait)
+			}
+			if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", accountLabels(testAccount())); !ok || got != 80 {
+				t.Errorf("got minutes viewed %v (exported %t) after the retry, want 80", got, ok)
+			}
+		})
+	}
+}
+
+func TestMaxRetryDuration(t *testing.T) {
+	// Backing off 50ms, 100ms, 200ms then 400ms between the 5 attempts.
+	tests := []struct {
+		name     string
+		max      time.Duration
+		requests int
+	}{
+		{"no cap", 0, 5},
+		{"cap past all retries", time.Second, 5},
+		{"cap cuts the retries", 300 * time.Millisecond, 3},
+		{"cap below the first backoff", 10 * time.Millisecond, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgRetryBackoff, 50*time.Millisecond)
+			setConfig(t, &cfgMaxRetries, 4)
+			setConfig(t, &cfgMaxRetryDuration, tt.max)
+			setConfig(t, &retryJitter, func(n int64) int64 { return n - 1 })
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			failing := graphqlFixture("StreamMinutesViewed", "")
+			failing.status, failing.body = http.StatusServiceUnavailable, "upstream unavailable"
+			m := newMockCloudflare(t, failing)
+
+			start := time.Now()
+			fetchStreamingAnalytics(context.Background(), testAccount())
+			elapsed := time.Since(start)
+
+			if got := len(m.requests("/graphql/")); got != tt.requests {
+				t.Errorf("got %d graphql requests, want %d", got, tt.requests)
+			}
+			if tt.max > 0 && elapsed > tt.max {
+				t.Errorf("retried for %s, want at most -max_retry_duration %s", elapsed, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", time.Second, 0, time.Second},
+		{"doubled", time.Second, 3, 8 * time.Second},
+		{"capped", time.Second, 5, maxRetryDelay},
+		// 1s<<34 would overflow into negative durations without the cap.
+		{"no overflow", time.Second, 34, maxRetryDelay},
+		{"no overflow past 63", time.Second, 100, maxRetryDelay},
+		{"backoff past the cap", time.Minute, 10, time.Minute},
+		{"no backoff", 0, 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgRetryBackoff, tt.backoff)
+			if got := backoffCeiling(tt.attempt); got != tt.want {
+				t.Errorf("got ceiling %s, want %s", got, tt.want)
+			}
+			for i := 0; i < 100; i++ {
+				if got := retryDelay(nil, tt.attempt, time.Now()); got < 0 || got > tt.want {
+					t.Fatalf("got delay %s, want within [0, %s]", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reservedPaths are served by the exporter itself, a metrics path on one of
+// them would silently shadow it or be shadowed.
+var reservedPaths = []string{"/", "/health", "/query", "/-/ready", "/-/healthy", "/-/refresh", "/debug/last_response", "/config"}
+
+func validateMetricsPath(path string) error {
+	if contains(reservedPaths, path) || contains(reservedPaths, strings.TrimSuffix(path, "/")) {
+		return fmt.Errorf("metrics path %q collides with a reserved path (%s)", path, strings.Join(reservedPaths, ", "))
+	}
+	return nil
+}
+
+// normalizeRoutePrefix turns -route_prefix into "" or a path starting but
+// not ending with a slash, so routes are joined as prefix + path.
+func normalizeRoutePrefix(prefix string) (string, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if len(prefix) > 0 && !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("-route_prefix %q must start with /", prefix)
+	}
+	return prefix, nil
+}
+
+// route is the path the exporter serves path at under -route_prefix.
+func route(path string) string {
+	return cfgRoutePrefix + path
+}
+
+// registerRoutes serves the exporter's endpoints under -route_prefix and
+// rewrites metricsPaths to the paths they are served at.
+func registerRoutes(metricsPaths []string, constLabels prometheus.Labels) {
+	for i, path := range metricsPaths {
+		path = route(path)
+		metricsPaths[i] = path
+		http.Handle(path, allowMethods(metricsHandler(), readMethods...))
+		if cfgDatasetPaths {
+			registerDatasetPaths(strings.TrimSuffix(path, "/"), constLabels)
+		}
+		if tenantPath := strings.TrimSuffix(path, "/") + "/"; tenantPath != path {
+			http.Handle(tenantPath, allowMethods(tenantHandler(tenantPath, constLabels), readMethods...))
+		}
+	}
+	registerHealthEndpoint()
+	http.Handle(route("/-/refresh"), allowMethods(http.HandlerFunc(refreshHandler), http.MethodPost))
+	// /query sends a graphql query per account on every request, so it is
+	// opt-in like the other debug endpoints.
+	if cfgEnableDebugEndpoints {
+		http.Handle(route("/query"), allowMethods(http.HandlerFunc(queryHandler), readMethods...))
+		http.Handle(route("/debug/last_response"), allowMethods(http.HandlerFunc(lastResponseHandler), readMethods...))
+		http.Handle(route("/config"), allowMethods(http.HandlerFunc(configHandler), readMethods...))
+	}
+}
+
+// parseMetricsPaths splits the comma-separated -metrics_path, so the metrics
+// can also be served at an old path while scrape configs migrate.
+func parseMetricsPaths(raw string) ([]string, error) {
+	var paths []string
+	for _, path := range splitList(raw) {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		if err := validateMetricsPath(path); err != nil {
+			return nil, err
+		}
+		if contains(paths, path) {
+			return nil, fmt.Errorf("metrics path %q is listed twice", path)
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("-metrics_path must not be empty")
+	}
+	return paths, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestMetricsPathCollision(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{"/metrics", false},
+		{"metrics", false},
+		{"/health", true},
+		{"/health/", true},
+		{"health", true},
+		{"/", true},
+		{"/-/ready", true},
+		{"/config", true},
+		{"/metrics,/health", true},
+		{"/healthz", false},
+	}
+	for _, tt := range tests {
+		_, err := parseMetricsPaths(tt.raw)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("parseMetricsPaths(%q) error = %v, want error %t", tt.raw, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMultipleMetricsPaths(t *testing.T) {
+	paths, err := parseMetricsPaths("/metrics, prometheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(paths) != "[/metrics /prometheus]" {
+		t.Fatalf("got paths %v, want /metrics and /prometheus", paths)
+	}
+	if _, err := parseMetricsPaths("/metrics,metrics"); err == nil {
+		t.Error("parseMetricsPaths accepted a path listed twice")
+	}
+
+	resetMetrics(t)
+	setMinutesViewed(testAccount(), 80)
+	mux := http.NewServeMux()
+	for _, path := range paths {
+		mux.Handle(path, allowMethods(metricsHandler(), readMethods...))
+	}
+
+	var served []map[string]string
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s returned %d", path, rec.Code)
+		}
+		families, err := new(expfmt.TextParser).TextToMetricFamilies(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// promhttp counts the requests to the handlers themselves.
+		metrics := map[string]string{}
+		for name, mf := range families {
+			if strings.HasPrefix(name, "cloudflare_") {
+				metrics[name] = mf.String()
+			}
+		}
+		served = append(served, metrics)
+	}
+	if _, ok := served[0]["cloudflare_streaming_minutes_viewed"]; !ok {
+		t.Fatalf("%s does not serve the minutes viewed", paths[0])
+	}
+	if !reflect.DeepEqual(served[0], served[1]) {
+		t.Errorf("%s and %s serve different metrics", paths[0], paths[1])
+	}
+}
+
+func TestNormalizeRoutePrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{prefix: "", want: ""},
+		{prefix: "/", want: ""},
+		{prefix: "/exporter", want: "/exporter"},
+		{prefix: "/exporter/", want: "/exporter"},
+		{prefix: "/a/b/", want: "/a/b"},
+		{prefix: "exporter", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeRoutePrefix(tt.prefix)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeRoutePrefix(%q) = %q, %v, want %q, error %t", tt.prefix, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRoutePrefix(t *testing.T) {
+	prefix, err := normalizeRoutePrefix("/exporter/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, &cfgRoutePrefix, prefix)
+	setConfig(t, &cfgEnableDebugEndpoints, true)
+	setConfig(t, &http.DefaultServeMux, http.NewServeMux())
+	setConfig(t, &lastAccounts, &accountSet{})
+	lastAccounts.set([]monitoredAccount{testAccount()})
+	resetMetrics(t)
+
+	metricsPaths := []string{"/metrics"}
+	registerRoutes(metricsPaths, nil)
+	if metricsPaths[0] != "/exporter/metrics" {
+		t.Errorf("got metrics path %s, want it under the prefix", metricsPaths[0])
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/exporter/metrics", http.StatusOK},
+		{http.MethodGet, "/exporter/health", http.StatusOK},
+		{http.MethodGet, "/exporter/config", http.StatusOK},
+		{http.MethodGet, "/exporter/metrics/" + testAccount().ID, http.StatusOK},
+		// Registered for POST only.
+		{http.MethodGet, "/exporter/-/refresh", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/metrics", http.StatusNotFound},
+		{http.MethodGet, "/health", http.StatusNotFound},
+		{http.MethodGet, "/config", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+}
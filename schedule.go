@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var cfScrapeScheduledOff = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "cloudflare_stream_scrape_scheduled_off",
+	Help: "Whether scraping is currently paused because it is outside -scrape_schedule",
+})
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// scrapeSchedule is a daily time window, optionally restricted to a range of
+// weekdays. A window ending before it starts spans midnight.
+type scrapeSchedule struct {
+	days       [7]bool
+	start, end time.Duration
+}
+
+var activeSchedule *scrapeSchedule
+
+// parseScrapeSchedule parses "[Mon-Fri ]HH:MM-HH:MM" in local time. A window
+// starting when it ends is rejected rather than read as never or all day,
+// -scrape_schedule is simply left unset to scrape around the clock.
+func parseScrapeSchedule(raw string) (*scrapeSchedule, error) {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid -scrape_schedule %q, expected [Mon-Fri ]HH:MM-HH:MM", raw)
+	}
+
+	s := &scrapeSchedule{}
+	if len(fields) == 2 {
+		first, last, err := parseWeekdays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid -scrape_schedule days %q: %s", fields[0], err)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			s.days[d] = true
+			if d == last {
+				break
+			}
+		}
+		fields = fields[1:]
+	} else {
+		s.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	start, end, err := parseHours(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid -scrape_schedule hours %q: %s", fields[0], err)
+	}
+	s.start, s.end = start, end
+
+	return s, nil
+}
+
+func parseWeekdays(raw string) (time.Weekday, time.Weekday, error) {
+	from, to, ok := strings.Cut(raw, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a range separated by -")
+	}
+	first, ok := weekdays[strings.ToLower(from)]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown weekday %q", from)
+	}
+	last, ok := weekdays[strings.ToLower(to)]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown weekday %q", to)
+	}
+	return first, last, nil
+}
+
+func parseHours(raw string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(raw, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a range separated by -")
+	}
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("the window starts when it ends, leave -scrape_schedule unset to scrape all day")
+	}
+	return start, end, nil
+}
+
+func parseTimeOfDay(raw string) (time.Duration, error) {
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active reports whether now falls within the schedule. For windows spanning
+// midnight the weekday is the one the window started on.
+func (s *scrapeSchedule) active(now time.Time) bool {
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	day := now.Weekday()
+
+	if s.start <= s.end {
+		return s.days[day] && offset >= s.start && offset < s.end
+	}
+	if offset >= s.start {
+		return s.days[day]
+	}
+	return offset < s.end && s.days[(day+6)%7]
+}
+
+// scheduledOff reports whether scrapes are currently paused and keeps the
+// corresponding gauge up to date.
+func scheduledOff(now time.Time) bool {
+	if activeSchedule == nil || activeSchedule.active(now) {
+		cfScrapeScheduledOff.Set(0)
+		return false
+	}
+	cfScrapeScheduledOff.Set(1)
+	return true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeSchedule(t *testing.T) {
+	// 2022-09-02 is a Friday.
+	at := func(day int, clock string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprintf("2022-09-%02d %s", day, clock), time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		schedule string
+		now      time.Time
+		want     bool
+	}{
+		{"09:00-17:00", at(2, "08:59"), false},
+		{"09:00-17:00", at(2, "09:00"), true},
+		{"09:00-17:00", at(2, "16:59"), true},
+		{"09:00-17:00", at(2, "17:00"), false},
+		{"Mon-Fri 09:00-17:00", at(2, "12:00"), true},
+		{"Mon-Fri 09:00-17:00", at(3, "12:00"), false},
+		{"mon-fri 09:00-17:00", at(5, "12:00"), true},
+		// Spanning midnight, the early hours belong to the previous day.
+		{"22:00-02:00", at(2, "23:00"), true},
+		{"22:00-02:00", at(3, "01:59"), true},
+		{"22:00-02:00", at(3, "12:00"), false},
+		{"Mon-Fri 22:00-02:00", at(3, "01:00"), true},
+		{"Mon-Fri 22:00-02:00", at(3, "23:00"), false},
+		{"Sat-Sun 00:00-23:59", at(4, "10:00"), true},
+	}
+	for _, tt := range tests {
+		s, err := parseScrapeSchedule(tt.schedule)
+		if err != nil {
+			t.Fatalf("parseScrapeSchedule(%q): %s", tt.schedule, err)
+		}
+		if got := s.active(tt.now); got != tt.want {
+			t.Errorf("%q active at %s = %t, want %t", tt.schedule, tt.now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"", "9-17", "Mon 09:00-17:00", "Mon-Fry 09:00-17:00", "09:00-25:00", "Mon-Fri 09:00-17:00 extra", "00:00-00:00", "Mon-Fri 09:00-09:00"} {
+		if _, err := parseScrapeSchedule(raw); err == nil {
+			t.Errorf("parseScrapeSchedule(%q) accepted an invalid schedule", raw)
+		}
+	}
+}
+
+func TestScrapeSkippedOffSchedule(t *testing.T) {
+	later := time.Now().Add(2 * time.Hour)
+	schedule, err := parseScrapeSchedule(later.Format("15:04") + "-" + later.Add(time.Hour).Format("15:04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, &activeSchedule, schedule)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts_empty.json"))
+
+	runScrapeAndPush(context.Background())
+	if got := len(m.requests("/client/v4/accounts")); got != 0 {
+		t.Errorf("got %d accounts requests outside the schedule, want none", got)
+	}
+	if got := testutil.ToFloat64(cfScrapeScheduledOff); got != 1 {
+		t.Errorf("got scheduled off %v, want 1", got)
+	}
+
+	setConfig(t, &activeSchedule, nil)
+	runScrapeAndPush(context.Background())
+	if got := len(m.requests("/client/v4/accounts")); got != 1 {
+		t.Errorf("got %d accounts requests without a schedule, want 1", got)
+	}
+	if got := testutil.ToFloat64(cfScrapeScheduledOff); got != 0 {
+		t.Errorf("got scheduled off %v, want 0", got)
+	}
+}
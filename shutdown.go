@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// waitForShutdown blocks until SIGTERM or SIGINT, then drains the metrics and
+// stops the http server within timeout. The final scrape gets at most half of
+// it, so the server always keeps time to drain its connections. SIGHUP
+// reloads the listen address.
+func waitForShutdown(server *http.Server, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt, syscall.SIGHUP)
+	sig := <-signals
+	for ; sig == syscall.SIGHUP; sig = <-signals {
+		server = reloadListener(server, timeout)
+	}
+	log.Infof("Received %s, shutting down", sig)
+
+	start := time.Now()
+	drainToPushgateway(timeout / 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout-time.Since(start))
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakePushgateway records the bodies pushed to it.
+type fakePushgateway struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	pushes []string
+}
+
+func newFakePushgateway(t *testing.T) *fakePushgateway {
+	p := &fakePushgateway{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		p.mu.Lock()
+		p.pushes = append(p.pushes, r.Method+" "+r.URL.Path+"\n"+string(body))
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakePushgateway) received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.pushes...)
+}
+
+func TestShutdownPushesFinalScrape(t *testing.T) {
+	pushgateway := newFakePushgateway(t)
+	setConfig(t, &cfgPushgatewayURL, pushgateway.URL)
+	setConfig(t, &cfIncludeAccounts, testAccount().ID)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	server, err := serve("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keeps SIGTERM from killing the test binary before waitForShutdown
+	// catches it.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		waitForShutdown(server, 5*time.Second)
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for stopped := false; !stopped; {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+			stopped = true
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("waitForShutdown did not return after SIGTERM")
+		}
+	}
+
+	pushes := pushgateway.received()
+	if len(pushes) != 1 {
+		t.Fatalf("got %d pushes, want the final one", len(pushes))
+	}
+	if !strings.HasPrefix(pushes[0], http.MethodPut+" /metrics/job/"+cfgPushgatewayJob) {
+		t.Errorf("got push %q, want a PUT to the -pushgateway_job group", strings.SplitN(pushes[0], "\n", 2)[0])
+	}
+	if !strings.Contains(pushes[0], "cloudflare_streaming_minutes_viewed") {
+		t.Error("final push does not carry the minutes viewed of the final scrape")
+	}
+}
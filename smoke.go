@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runSmokeTest checks credentials and network path end-to-end by listing the
+// accounts and running a single streaming analytics query.
+func runSmokeTest(ctx context.Context) error {
+	accounts, err := monitoredAccounts(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching accounts: %w", err)
+	}
+	if len(accounts) == 0 {
+		return errors.New("token does not give access to any monitored account")
+	}
+	log.Infof("Smoke test: token can see %d monitored accounts", len(accounts))
+
+	account := accounts[0]
+	start, end := queryWindow(cfgLookback)
+	resp, err := fetchStreamingTotals(ctx, account, start, end)
+	if err != nil {
+		return fmt.Errorf("fetching streaming analytics for %s: %w", account.Name, err)
+	}
+
+	var minutes float64
+	for _, a := range resp.Viewer.Accounts {
+		for _, b := range a.AccountStreamMinutesViewedAdaptiveGroupsSum {
+			minutes += float64(b.minutes())
+		}
+	}
+	if minutes < 0 {
+		return fmt.Errorf("negative minutes viewed %f for %s", minutes, account.Name)
+	}
+	log.Infof("Smoke test: %s viewed %.0f minutes in the last %s", account.Name, minutes, cfgLookback)
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunSmokeTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures []mockFixture
+		wantErr  string
+	}{
+		{
+			name: "passes",
+			fixtures: []mockFixture{
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+			},
+		},
+		{
+			name:     "no account",
+			fixtures: []mockFixture{restFixture(http.MethodGet, "/accounts", "accounts_empty.json")},
+			wantErr:  "does not give access to any monitored account",
+		},
+		{
+			name: "graphql error",
+			fixtures: []mockFixture{
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				graphqlFixture("StreamMinutesViewed", "graphql_error.json"),
+			},
+			wantErr: "fetching streaming analytics for Acme Streaming",
+		},
+		{
+			name:    "accounts unavailable",
+			wantErr: "fetching accounts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t, tt.fixtures...)
+
+			err := runSmokeTest(context.Background())
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("runSmokeTest() = %s, want success", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runSmokeTest() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSmokeCloudflare runs the smoke test against the real cloudflare api, it
+// only runs when CF_API_TOKEN is set.
+func TestSmokeCloudflare(t *testing.T) {
+	token := os.Getenv("CF_API_TOKEN")
+	if len(token) == 0 {
+		t.Skip("CF_API_TOKEN not set")
+	}
+
+	tokens, err := parseAPITokens(token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, &apiTokens, tokens)
+	resetMetrics(t)
+
+	if err := runSmokeTest(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var cfAccountSuccessRatio = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "cloudflare_stream_account_success_ratio",
+	Help: "Share of the monitored accounts whose query succeeded in the last scrape cycle",
+})
+
+// scrapeSummary aggregates one scrape cycle for the summary log line.
+type scrapeSummary struct {
+	mu       sync.Mutex
+	accounts int
+	failed   int
+	minutes  uint64
+}
+
+var cycleSummary = &scrapeSummary{}
+
+func (s *scrapeSummary) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts, s.failed, s.minutes = 0, 0, 0
+}
+
+func (s *scrapeSummary) addAccounts(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts += n
+}
+
+func (s *scrapeSummary) addFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failed++
+}
+
+func (s *scrapeSummary) addMinutes(minutes uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.minutes += minutes
+}
+
+// updateSuccessRatio sets the success ratio of the cycle. A cycle without
+// monitored accounts, e.g. when listing them failed, leaves it unchanged.
+func (s *scrapeSummary) updateSuccessRatio() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accounts == 0 {
+		return
+	}
+	cfAccountSuccessRatio.Set(float64(s.accounts-s.failed) / float64(s.accounts))
+}
+
+func (s *scrapeSummary) log(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"accounts":       s.accounts,
+		"failed":         s.failed,
+		"minutes_viewed": s.minutes,
+		"duration":       d.Round(time.Millisecond).String(),
+	}).Info("Scrape cycle finished")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestScrapeSummaryLog(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	setConfig(t, &cfgMaxRetries, 0)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	acme := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+	acme.account = testAccount().ID
+	failing := graphqlFixture("StreamMinutesViewed", "")
+	failing.account, failing.status, failing.body = staging, http.StatusInternalServerError, "upstream unavailable"
+	newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"), acme, failing)
+
+	level := log.GetLevel()
+	log.SetLevel(log.InfoLevel)
+	t.Cleanup(func() { log.SetLevel(level) })
+	hook := test.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(log.LevelHooks{}) })
+
+	fetchMetrics(context.Background())
+
+	var summaries []*log.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Message == "Scrape cycle finished" {
+			summaries = append(summaries, e)
+		}
+		if e.Level == log.InfoLevel && strings.Contains(e.Message, "Acme") {
+			t.Errorf("per-account line logged at info: %q", e.Message)
+		}
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("got %d summary lines, want 1", len(summaries))
+	}
+	summary := summaries[0]
+	if summary.Level != log.InfoLevel {
+		t.Errorf("summary logged at %s, want info", summary.Level)
+	}
+	want := log.Fields{"accounts": 2, "failed": 1, "minutes_viewed": uint64(240)}
+	for name, value := range want {
+		if got := summary.Data[name]; got != value {
+			t.Errorf("got %s=%v, want %v", name, got, value)
+		}
+	}
+	if d, ok := summary.Data["duration"].(string); !ok || !strings.HasSuffix(d, "s") {
+		t.Errorf("got duration %v, want a rounded duration", summary.Data["duration"])
+	}
+}
+
+func TestAccountSuccessRatio(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	tests := []struct {
+		name   string
+		failed []string
+		want   float64
+	}{
+		{"all succeed", nil, 1},
+		{"one of two fails", []string{staging}, 0.5},
+		{"all fail", []string{testAccount().ID, staging}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgMaxRetries, 0)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			fixtures := []mockFixture{restFixture(http.MethodGet, "/accounts", "accounts.json")}
+			for _, id := range tt.failed {
+				failing := graphqlFixture("StreamMinutesViewed", "")
+				failing.account, failing.status, failing.body = id, http.StatusInternalServerError, "upstream unavailable"
+				fixtures = append(fixtures, failing)
+			}
+			m := newMockCloudflare(t, append(fixtures, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))...)
+
+			fetchMetrics(context.Background())
+			if got := testutil.ToFloat64(cfAccountSuccessRatio); got != tt.want {
+				t.Errorf("got success ratio %v, want %v", got, tt.want)
+			}
+
+			// A cycle that could not list the accounts keeps the last ratio.
+			m.mu.Lock()
+			m.fixtures = []mockFixture{{method: http.MethodGet, path: "/client/v4/accounts", status: http.StatusForbidden, body: readTestdata(t, "rest_error.json")}}
+			m.mu.Unlock()
+			setConfig(t, &lastAccounts, &accountSet{})
+			fetchMetrics(context.Background())
+			if got := testutil.ToFloat64(cfAccountSuccessRatio); got != tt.want {
+				t.Errorf("got success ratio %v after a cycle without accounts, want %v kept", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// tenantRegistries hold the per-account metrics, one registry per account and
+// dataset. A tenant path gathers only the registries of its account, so it
+// cannot expose the series of another account whatever their labels. The
+// dataset is "" for per-account metrics outside any dataset.
+type tenantRegistries struct {
+	mu         sync.RWMutex
+	regs       map[string]map[string]*prometheus.Registry
+	collectors map[string][]prometheus.Collector
+}
+
+var tenants = &tenantRegistries{regs: map[string]map[string]*prometheus.Registry{}}
+
+func (t *tenantRegistries) register(id, dataset string, c prometheus.Collector) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byDataset, ok := t.regs[id]
+	if !ok {
+		byDataset = map[string]*prometheus.Registry{}
+		t.regs[id] = byDataset
+	}
+	reg, ok := byDataset[dataset]
+	if !ok {
+		reg = prometheus.NewRegistry()
+		byDataset[dataset] = reg
+	}
+	reg.MustRegister(c)
+
+	if t.collectors == nil {
+		t.collectors = map[string][]prometheus.Collector{}
+	}
+	t.collectors[id] = append(t.collectors[id], c)
+}
+
+// deletePartialMatch deletes the series of every metric of the account that
+// match labels.
+func (t *tenantRegistries) deletePartialMatch(id string, labels prometheus.Labels) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, c := range t.collectors[id] {
+		switch c := c.(type) {
+		case *prometheus.GaugeVec:
+			c.DeletePartialMatch(labels)
+		case *bucketCollector:
+			c.deletePartialMatch(labels)
+		case *windowHistogram:
+			c.deletePartialMatch(labels)
+		}
+	}
+}
+
+// gatherer merges the registries keep accepts. They are looked up on every
+// gather since accounts get their registries on their first fetch.
+func (t *tenantRegistries) gatherer(keep func(id, dataset string) bool) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		t.mu.RLock()
+		var gatherers prometheus.Gatherers
+		for id, byDataset := range t.regs {
+			for dataset, reg := range byDataset {
+				if keep(id, dataset) {
+					gatherers = append(gatherers, reg)
+				}
+			}
+		}
+		t.mu.RUnlock()
+
+		return gatherers.Gather()
+	})
+}
+
+func (t *tenantRegistries) account(id string) prometheus.Gatherer {
+	return t.gatherer(func(accountID, _ string) bool { return accountID == id })
+}
+
+func (t *tenantRegistries) dataset(name string) prometheus.Gatherer {
+	return t.gatherer(func(_, dataset string) bool { return dataset == name })
+}
+
+func (t *tenantRegistries) all() prometheus.Gatherer {
+	return t.gatherer(func(string, string) bool { return true })
+}
+
+// accountGaugeVec is a gauge vec split per account: each account gets its own
+// vec, registered in its tenant registry for dataset on first use.
+type accountGaugeVec struct {
+	dataset string
+	opts    prometheus.GaugeOpts
+	labels  []string
+
+	mu   sync.Mutex
+	vecs map[string]*prometheus.GaugeVec
+}
+
+func newAccountGaugeVec(dataset string, opts prometheus.GaugeOpts, labelNames []string) *accountGaugeVec {
+	return &accountGaugeVec{dataset: dataset, opts: opts, labels: labelNames, vecs: map[string]*prometheus.GaugeVec{}}
+}
+
+func (v *accountGaugeVec) of(account monitoredAccount) *prometheus.GaugeVec {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	vec, ok := v.vecs[account.ID]
+	if !ok {
+		vec = prometheus.NewGaugeVec(v.opts, v.labels)
+		tenants.register(account.ID, v.dataset, vec)
+		v.vecs[account.ID] = vec
+	}
+	return vec
+}
+
+// set writes the series unless -max_series is reached, in which case a value
+// left by an earlier scrape is deleted rather than kept stale.
+func (v *accountGaugeVec) set(account monitoredAccount, labels prometheus.Labels, value float64) {
+	if !seriesLimit.allow(v.opts.Name, labels) {
+		v.of(account).Delete(labels)
+		return
+	}
+	v.of(account).With(labels).Set(value)
+}
+
+func (v *accountGaugeVec) inc(account monitoredAccount, labels prometheus.Labels) {
+	if !seriesLimit.allow(v.opts.Name, labels) {
+		v.of(account).Delete(labels)
+		return
+	}
+	v.of(account).With(labels).Inc()
+}
+
+func (v *accountGaugeVec) delete(account monitoredAccount, labels prometheus.Labels) {
+	v.of(account).Delete(labels)
+}
+
+func (v *accountGaugeVec) deletePartialMatch(account monitoredAccount, labels prometheus.Labels) {
+	v.of(account).DeletePartialMatch(labels)
+}
+
+// accountBucketCollector splits the -use_bucket_timestamps viewed metric per
+// account like accountGaugeVec.
+type accountBucketCollector struct {
+	name, help string
+	labels     []string
+
+	mu         sync.Mutex
+	collectors map[string]*bucketCollector
+}
+
+func newAccountBucketCollector(name, help string, labelNames []string) *accountBucketCollector {
+	return &accountBucketCollector{name: name, help: help, labels: labelNames, collectors: map[string]*bucketCollector{}}
+}
+
+func (c *accountBucketCollector) of(account monitoredAccount) *bucketCollector {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	collector, ok := c.collectors[account.ID]
+	if !ok {
+		collector = newBucketCollector(c.name, c.help, c.labels)
+		tenants.register(account.ID, "viewed", collector)
+		c.collectors[account.ID] = collector
+	}
+	return collector
+}
+
+func (c *accountBucketCollector) set(account monitoredAccount, labels prometheus.Labels, value float64, ts time.Time) {
+	if !seriesLimit.allow(c.name, labels) {
+		c.of(account).deletePartialMatch(labels)
+		return
+	}
+	c.of(account).set(labels, value, ts)
+}
+
+func (c *accountBucketCollector) deletePartialMatch(account monitoredAccount, labels prometheus.Labels) {
+	c.of(account).deletePartialMatch(labels)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestTenantRegistries(t *testing.T) {
+	const staging = "7c5dae5552338874e5053f2534d2767a"
+	setConfig(t, &lastAccounts, &accountSet{})
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	other := graphqlFixture("StreamMinutesViewed", "")
+	other.account, other.body = staging, `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [{"sum": {"minutesViewed": 5}, "dimensions": {"ts": "2022-09-01T10:00:00Z"}}]}]}}}`
+	acme := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+	acme.account = testAccount().ID
+	newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"), other, acme)
+
+	fetchMetrics(context.Background())
+
+	handler := tenantHandler("/metrics/", prometheus.Labels{"region": "eu"})
+	tests := []struct {
+		id      string
+		status  int
+		minutes float64
+	}{
+		{testAccount().ID, http.StatusOK, 80},
+		{staging, http.StatusOK, 5},
+		{"4f1ab7e8e07113a7e06c9ae2b3a4bc1a", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/"+tt.id, nil))
+		if rec.Code != tt.status {
+			t.Fatalf("/metrics/%s returned %d, want %d", tt.id, rec.Code, tt.status)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		families, err := new(expfmt.TextParser).TextToMetricFamilies(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := families["cloudflare_stream_scrape_cycles_total"]; ok {
+			t.Errorf("/metrics/%s serves exporter-wide metrics", tt.id)
+		}
+		for name, mf := range families {
+			for _, m := range mf.GetMetric() {
+				labels := map[string]string{}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["account_id"] != tt.id || labels["region"] != "eu" {
+					t.Errorf("/metrics/%s serves %s%v", tt.id, name, labels)
+				}
+			}
+		}
+		mf, ok := families["cloudflare_streaming_minutes_viewed"]
+		if !ok || mf.GetMetric()[0].GetGauge().GetValue() != tt.minutes {
+			t.Errorf("/metrics/%s: got minutes viewed %v, want %v", tt.id, mf, tt.minutes)
+		}
+		if _, ok := families["cloudflare_stream_consecutive_scrape_failures"]; !ok {
+			t.Errorf("/metrics/%s misses the metrics of the account outside the viewed dataset", tt.id)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type apiToken struct {
+	name  string
+	value string
+}
+
+// monitoredAccount is an account together with the token it was enumerated
+// with, so follow-up queries authenticate the same way.
+type monitoredAccount struct {
+	cloudflare.Account
+	token apiToken
+}
+
+// accountKey tells apart the same account seen through different tokens.
+func accountKey(account monitoredAccount) string {
+	return account.ID + "/" + account.token.name
+}
+
+var apiTokens []apiToken
+
+func parseAPITokens(tokens, names string) ([]apiToken, error) {
+	values := strings.Split(tokens, ",")
+	var tokenNames []string
+	if len(names) > 0 {
+		tokenNames = strings.Split(names, ",")
+		if len(tokenNames) != len(values) {
+			return nil, fmt.Errorf("got %d token names for %d tokens", len(tokenNames), len(values))
+		}
+	}
+
+	parsed := make([]apiToken, 0, len(values))
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			return nil, errNoAPIToken
+		}
+
+		name := fmt.Sprintf("token%d", i)
+		if tokenNames != nil {
+			name = strings.TrimSpace(tokenNames[i])
+		}
+		parsed = append(parsed, apiToken{name: name, value: v})
+	}
+
+	return parsed, nil
+}
+
+func validateLabelBy(labelBy string) error {
+	switch labelBy {
+	case "id", "name", "both":
+		return nil
+	default:
+		return fmt.Errorf("unsupported -label_by %q, expected id, name or both", labelBy)
+	}
+}
+
+// accountLabelNames returns the labels identifying an account on per-account
+// metrics, the name and/or the ID depending on -label_by. With several tokens
+// the same account can be enumerated more than once, so the token name is
+// added to keep those series apart.
+func accountLabelNames() []string {
+	var names []string
+	if cfgLabelBy != "id" {
+		names = append(names, "account")
+	}
+	if cfgLabelBy != "name" {
+		names = append(names, "account_id")
+	}
+	if len(apiTokens) > 1 {
+		names = append(names, "token_name")
+	}
+	return names
+}
+
+func accountLabels(a monitoredAccount) prometheus.Labels {
+	labels := prometheus.Labels{}
+	if cfgLabelBy != "id" {
+		labels["account"] = accountDisplayName(a)
+	}
+	if cfgLabelBy != "name" {
+		labels["account_id"] = a.ID
+	}
+	if len(apiTokens) > 1 {
+		labels["token_name"] = a.token.name
+	}
+	return labels
+}
+
+func warnAccountCollisions(accounts []monitoredAccount) {
+	seen := map[string][]string{}
+	for _, a := range accounts {
+		seen[a.ID] = append(seen[a.ID], a.token.name)
+	}
+
+	for id, names := range seen {
+		if len(names) > 1 {
+			log.Warnf("Account %s is visible to tokens %s, its series are distinguished by token_name", id, strings.Join(names, ", "))
+		}
+	}
+}
+
+func fetchAllAccounts(ctx context.Context) ([]monitoredAccount, error) {
+	if ids := splitList(cfgAccountIDs); len(ids) > 0 {
+		return explicitAccounts(ctx, ids), nil
+	}
+
+	var accounts []monitoredAccount
+	var lastErr error
+	succeeded := 0
+	for _, t := range apiTokens {
+		a, err := fetchAccounts(ctx, t)
+		if err != nil {
+			log.Errorf("Fetching accounts with token %s: %s", t.name, err)
+			lastErr = err
+			continue
+		}
+		succeeded++
+
+		for _, account := range a {
+			if len(account.Name) == 0 {
+				account.Name = unknownAccountName(account.ID)
+			}
+			accounts = append(accounts, monitoredAccount{Account: account, token: t})
+		}
+	}
+
+	if succeeded == 0 {
+		if lastErr == nil {
+			lastErr = errNoAPIToken
+		}
+		return nil, lastErr
+	}
+
+	warnAccountCollisions(accounts)
+	return accounts, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFetchAccountsWithoutToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []apiToken
+	}{
+		{name: "empty token", tokens: []apiToken{{name: "token0"}}},
+		{name: "no token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &apiTokens, tt.tokens)
+
+			if len(tt.tokens) > 0 {
+				if _, err := fetchAccounts(context.Background(), tt.tokens[0]); !errors.Is(err, errNoAPIToken) {
+					t.Errorf("fetchAccounts() error = %v, want %v", err, errNoAPIToken)
+				}
+			}
+			if _, err := fetchAllAccounts(context.Background()); !errors.Is(err, errNoAPIToken) {
+				t.Errorf("fetchAllAccounts() error = %v, want %v", err, errNoAPIToken)
+			}
+		})
+	}
+}
+
+func TestParseAPITokens(t *testing.T) {
+	tests := []struct {
+		tokens, names string
+		want          []apiToken
+		wantErr       bool
+	}{
+		{tokens: "a", want: []apiToken{{name: "token0", value: "a"}}},
+		{tokens: "a, b", want: []apiToken{{name: "token0", value: "a"}, {name: "token1", value: "b"}}},
+		{tokens: "a,b", names: "prod, staging", want: []apiToken{{name: "prod", value: "a"}, {name: "staging", value: "b"}}},
+		{tokens: "a,b", names: "prod", wantErr: true},
+		{tokens: "a,,b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAPITokens(tt.tokens, tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAPITokens(%q, %q) error = %v, want error %t", tt.tokens, tt.names, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAPITokens(%q, %q) = %v, want %v", tt.tokens, tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestOverlappingTokens(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{{name: "prod", value: "prod-token"}, {name: "staging", value: "staging-token"}})
+	resetMetrics(t)
+	prod := restFixture(http.MethodGet, "/accounts", "accounts.json")
+	prod.token = "prod-token"
+	staging := restFixture(http.MethodGet, "/accounts", "accounts_second_token.json")
+	staging.token = "staging-token"
+	newMockCloudflare(t, prod, staging, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+	fetchMetrics(context.Background())
+
+	// Acme Streaming is visible to both tokens and gets a series for each.
+	if got := countSeries(t, tenants.all(), "cloudflare_streaming_minutes_viewed"); got != 4 {
+		t.Errorf("got %d minutes viewed series, want 4", got)
+	}
+	for _, token := range []string{"prod", "staging"} {
+		labels := map[string]string{"account": "Acme Streaming", "account_id": testAccount().ID, "token_name": token}
+		if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", labels); !ok || got != 80 {
+			t.Errorf("got %v (exported %t) for token %s, want 80", got, ok, token)
+		}
+	}
+}
+
+func TestLabelBy(t *testing.T) {
+	tests := []struct {
+		labelBy string
+		want    prometheus.Labels
+	}{
+		{"both", prometheus.Labels{"account": "Acme Streaming", "account_id": testAccount().ID}},
+		{"name", prometheus.Labels{"account": "Acme Streaming"}},
+		{"id", prometheus.Labels{"account_id": testAccount().ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.labelBy, func(t *testing.T) {
+			if err := validateLabelBy(tt.labelBy); err != nil {
+				t.Fatal(err)
+			}
+			setConfig(t, &cfgLabelBy, tt.labelBy)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t,
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+			)
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			if got, ok := gatheredValue(t, tenants.all(), "cloudflare_streaming_minutes_viewed", tt.want); !ok || got != 80 {
+				t.Errorf("got minutes viewed %v (exported %t) with labels %v, want 80", got, ok, tt.want)
+			}
+		})
+	}
+
+	if err := validateLabelBy("uuid"); err == nil {
+		t.Error("validateLabelBy accepted an unsupported value")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestScrapeSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	setConfig(t, &tracer, provider.Tracer("test"))
+
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &cfIncludeAccounts, testAccount().ID)
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	fetchMetrics(context.Background())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	tests := []struct {
+		name, parent string
+		attribute    string
+		want         string
+	}{
+		{name: "fetchMetrics"},
+		{name: "fetchAccounts", parent: "fetchMetrics", attribute: "token.name", want: "token0"},
+		{name: "fetchStreamingTotals", parent: "fetchMetrics", attribute: "account.id", want: testAccount().ID},
+	}
+	for _, tt := range tests {
+		s, ok := spans[tt.name]
+		if !ok {
+			t.Errorf("no %s span, got %v", tt.name, spans)
+			continue
+		}
+		if len(tt.parent) > 0 && s.Parent().TraceID() != spans[tt.parent].SpanContext().TraceID() {
+			t.Errorf("%s span is not part of the %s trace", tt.name, tt.parent)
+		}
+		if len(tt.attribute) == 0 {
+			continue
+		}
+		var got string
+		for _, a := range s.Attributes() {
+			if string(a.Key) == tt.attribute {
+				got = a.Value.AsString()
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s span has %s=%q, want %q", tt.name, tt.attribute, got, tt.want)
+		}
+	}
+}
+
+func TestTracingNoopByDefault(t *testing.T) {
+	_, span := tracer.Start(context.Background(), "fetchMetrics")
+	defer span.End()
+
+	if span.IsRecording() {
+		t.Error("spans are recorded without -otel_endpoint")
+	}
+}
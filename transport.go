@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Registered by registerAPIMetrics once -histogram_buckets is known.
+var cfAPIRequestDuration *prometheus.HistogramVec
+
+// defaultHistogramBuckets spans 50ms to about 25s.
+var defaultHistogramBuckets = prometheus.ExponentialBuckets(0.05, 2, 10)
+
+func registerAPIMetrics(buckets []float64) {
+	cfAPIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cloudflare_stream_api_request_duration_seconds",
+		Help:    "Latency of requests to the cloudflare api",
+		Buckets: buckets,
+	}, []string{"endpoint"},
+	)
+}
+
+// parseHistogramBuckets parses -histogram_buckets, comma-separated upper
+// bounds in seconds, which must be positive and increasing.
+func parseHistogramBuckets(raw string) ([]float64, error) {
+	items := splitList(raw)
+	if len(items) == 0 {
+		return defaultHistogramBuckets, nil
+	}
+
+	buckets := make([]float64, 0, len(items))
+	for _, item := range items {
+		b, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -histogram_buckets bound %q: %w", item, err)
+		}
+		if b <= 0 {
+			return nil, fmt.Errorf("-histogram_buckets bound %s must be positive", item)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("-histogram_buckets must be increasing, %s follows %g", item, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// apiTransport is the round-tripper shared by the graphql and rest clients,
+// so every outbound cloudflare call is instrumented the same way.
+type apiTransport struct {
+	next http.RoundTripper
+}
+
+var apiClient = &http.Client{Transport: &apiTransport{next: http.DefaultTransport}}
+
+// apiEndpointLabel names the cloudflare endpoint a request targets: graphql
+// for analytics queries, otherwise the rest resource (e.g. accounts).
+func apiEndpointLabel(req *http.Request) string {
+	if strings.HasPrefix(req.URL.String(), cfGraphQLEndpoint) {
+		return "graphql"
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/client/v4")
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.Index(path, "/"); i >= 0 {
+		path = path[:i]
+	}
+	if len(path) == 0 {
+		return "unknown"
+	}
+
+	return path
+}
+
+// RoundTrip instruments the call and retries graphql requests, counting the
+// error codes of their responses. The rest client already retries on its own
+// and is rate limited here.
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if apiEndpointLabel(req) != "graphql" {
+		resp, err := t.roundTripREST(req)
+		if err != nil {
+			return restErrorResponse(req, err), nil
+		}
+		return resp, nil
+	}
+
+	resp, err := t.roundTripWithRetry(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readResponseBody(resp)
+	if errors.Is(err, errResponseTooLarge) {
+		cfGraphQLErrors.WithLabelValues("responseTooLarge").Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	countGraphQLErrors(body)
+	recordLastResponse(req.Context(), body)
+	return resp, nil
+}
+
+func (t *apiTransport) roundTripREST(req *http.Request) (*http.Response, error) {
+	endpoint := apiEndpointLabel(req)
+	if err := restLimit.wait(req); err != nil {
+		return nil, err
+	}
+	if endpoint == "accounts" {
+		return accountsETags.roundTrip(req, func(req *http.Request) (*http.Response, error) {
+			return t.instrumentedRoundTrip(req, endpoint)
+		})
+	}
+
+	return t.instrumentedRoundTrip(req, endpoint)
+}
+
+// restErrorResponse stands in for a rest request that failed without a
+// response. cloudflare-go dereferences the response of a failed request, so
+// the error is handed to it as an error status instead. Requests cut short by
+// their context get a 408, which cloudflare-go does not retry, other failures
+// a 502 it retries like any server error.
+func restErrorResponse(req *http.Request, err error) *http.Response {
+	status := http.StatusBadGateway
+	if req.Context().Err() != nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		status = http.StatusRequestTimeout
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"success": false,
+		"errors":  []map[string]interface{}{{"code": 0, "message": err.Error()}},
+	})
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func (t *apiTransport) instrumentedRoundTrip(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	cfAPIRequestDuration.With(prometheus.Labels{"endpoint": endpoint}).Observe(time.Since(start).Seconds())
+	if err == nil {
+		recordRateLimit(resp)
+	}
+
+	return resp, err
+}
+
+// useCAFile makes the api client trust the certificates of the PEM bundle at
+// path on top of the system pool, for networks intercepting tls.
+func useCAFile(path string) error {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in -ca_file %s", path)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	apiClient.Transport.(*apiTransport).next = transport
+	return nil
+}
+
+// graphqlEndpoints are the endpoints of the comma-separated
+// -cf_graphql_endpoint. The graphql clients post to the first one and the
+// transport spreads the requests across all of them.
+var graphqlEndpoints []*url.URL
+
+func parseGraphQLEndpoints(raw string) ([]*url.URL, error) {
+	var endpoints []*url.URL
+	for _, item := range splitList(raw) {
+		u, err := url.Parse(item)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid -cf_graphql_endpoint %q", item)
+		}
+		endpoints = append(endpoints, u)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("-cf_graphql_endpoint is empty")
+	}
+	return endpoints, nil
+}
+
+var nextGraphQLEndpoint uint32
+
+// roundTripEndpoints sends the request to the graphql endpoints in round
+// robin, failing over to the next one while the response is worth retrying.
+// Requests whose body cannot be replayed only get one endpoint.
+func (t *apiTransport) roundTripEndpoints(req *http.Request) (*http.Response, error) {
+	if len(graphqlEndpoints) < 2 {
+		return t.instrumentedRoundTrip(req, "graphql")
+	}
+
+	// The modulo is taken in uint32, an int conversion of the counter would
+	// turn negative past 2^31 on 32-bit platforms.
+	first := int(atomic.AddUint32(&nextGraphQLEndpoint, 1) % uint32(len(graphqlEndpoints)))
+	for i := 0; ; i++ {
+		endpoint := *graphqlEndpoints[(first+i)%len(graphqlEndpoints)]
+		attempt := req.Clone(req.Context())
+		attempt.URL, attempt.Host = &endpoint, endpoint.Host
+		if i > 0 {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+
+		resp, err := t.instrumentedRoundTrip(attempt, "graphql")
+		last := i == len(graphqlEndpoints)-1 || req.GetBody == nil || req.Context().Err() != nil
+		if last || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Warnf("GraphQL endpoint %s failed, failing over to the next one", endpoint.Host)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// histogramCount returns the number of observations of the histogram series
+// of name with labels in g.
+func histogramCount(t *testing.T, g prometheus.Gatherer, name string, labels prometheus.Labels) uint64 {
+	t.Helper()
+
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue series
+				}
+			}
+			return m.GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestAPIEndpointLabel(t *testing.T) {
+	setConfig(t, &cfGraphQLEndpoint, "https://api.cloudflare.com/client/v4/graphql/")
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://api.cloudflare.com/client/v4/graphql/", want: "graphql"},
+		{url: "https://api.cloudflare.com/client/v4/accounts?page=1&per_page=20", want: "accounts"},
+		{url: "https://api.cloudflare.com/client/v4/accounts/abc/stream/xyz", want: "accounts"},
+		{url: "https://api.cloudflare.com/client/v4/user/tokens/verify", want: "user"},
+		{url: "https://api.cloudflare.com/client/v4/", want: "unknown"},
+	}
+	for _, tt := range tests {
+		if got := apiEndpointLabel(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+			t.Errorf("apiEndpointLabel(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestAPIRequestDuration(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &cfIncludeAccounts, testAccount().ID)
+	resetMetrics(t)
+	newMockCloudflare(t,
+		restFixture(http.MethodGet, "/accounts", "accounts.json"),
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+	)
+
+	fetchMetrics(context.Background())
+
+	for _, endpoint := range []string{"accounts", "graphql"} {
+		got := histogramCount(t, prometheus.DefaultGatherer, "cloudflare_stream_api_request_duration_seconds", prometheus.Labels{"endpoint": endpoint})
+		if got != 1 {
+			t.Errorf("got %d observations for endpoint %s, want 1", got, endpoint)
+		}
+	}
+}
+
+func TestParseHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []float64
+		wantErr bool
+	}{
+		{raw: "", want: defaultHistogramBuckets},
+		{raw: "0.01, 0.1,1,10", want: []float64{0.01, 0.1, 1, 10}},
+		{raw: "0.5", want: []float64{0.5}},
+		{raw: "0.1,fast", wantErr: true},
+		{raw: "0,1", wantErr: true},
+		{raw: "-1,1", wantErr: true},
+		{raw: "1,0.5", wantErr: true},
+		{raw: "1,1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHistogramBuckets(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseHistogramBuckets(%q) = %v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHistogramBuckets(%q): %s", tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHistogramBuckets(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramBucketsApplied(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	setConfig(t, &prometheus.DefaultRegisterer, prometheus.Registerer(reg))
+	setConfig(t, &cfAPIRequestDuration, nil)
+
+	buckets, err := parseHistogramBuckets("0.25,1,4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerAPIMetrics(buckets)
+	cfAPIRequestDuration.WithLabelValues("graphql").Observe(0.5)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "cloudflare_stream_api_request_duration_seconds" {
+		t.Fatalf("got families %v, want the api latency histogram", families)
+	}
+	var bounds []float64
+	var counts []uint64
+	for _, b := range families[0].GetMetric()[0].GetHistogram().GetBucket() {
+		bounds = append(bounds, b.GetUpperBound())
+		counts = append(counts, b.GetCumulativeCount())
+	}
+	if !reflect.DeepEqual(bounds, []float64{0.25, 1, 4}) {
+		t.Errorf("got bucket bounds %v, want 0.25,1,4", bounds)
+	}
+	if !reflect.DeepEqual(counts, []uint64{0, 1, 1}) {
+		t.Errorf("got cumulative counts %v, want 0,1,1", counts)
+	}
+}
+
+func TestParseGraphQLEndpoints(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: "https://api.cloudflare.com/client/v4/graphql/", want: []string{"api.cloudflare.com"}},
+		{raw: "https://gw1.example.com/graphql, https://gw2.example.com/graphql", want: []string{"gw1.example.com", "gw2.example.com"}},
+		{raw: "", wantErr: true},
+		{raw: " , ", wantErr: true},
+		{raw: "gw1.example.com/graphql", wantErr: true},
+		{raw: "https://gw1.example.com/graphql,:bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		endpoints, err := parseGraphQLEndpoints(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseGraphQLEndpoints(%q) = %v, want an error", tt.raw, endpoints)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGraphQLEndpoints(%q): %s", tt.raw, err)
+			continue
+		}
+		var hosts []string
+		for _, u := range endpoints {
+			hosts = append(hosts, u.Host)
+		}
+		if !reflect.DeepEqual(hosts, tt.want) {
+			t.Errorf("parseGraphQLEndpoints(%q) = %v, want %v", tt.raw, hosts, tt.want)
+		}
+	}
+}
+
+func TestGraphQLFailover(t *testing.T) {
+	const fetches = 4
+	down := mockFixture{method: http.MethodPost, path: "/graphql/", status: http.StatusServiceUnavailable, body: "upstream unavailable"}
+	up := graphqlFixture("StreamMinutesViewed", "streaming_analytics.json")
+	tests := []struct {
+		name        string
+		first       mockFixture
+		second      mockFixture
+		counter     uint32
+		succeeded   int
+		firstCalls  int
+		secondCalls int
+	}{
+		// The fetches start at either endpoint in turn.
+		{"both up", up, up, 0, fetches, fetches / 2, fetches / 2},
+		{"first down", down, up, 0, fetches, fetches / 2, fetches},
+		{"second down", up, down, 0, fetches, fetches, fetches / 2},
+		// Each endpoint is tried once per fetch, then the fetch fails.
+		{"both down", down, down, 0, 0, fetches, fetches},
+		// The round robin carries on as the counter wraps around.
+		{"counter wrapping", up, up, math.MaxUint32 - 1, fetches, fetches / 2, fetches / 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgMaxRetries, 0)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			first := newMockCloudflare(t, tt.first)
+			second := newMockCloudflare(t, tt.second)
+			setConfig(t, &cfGraphQLEndpoint, first.URL+"/graphql/,"+second.URL+"/graphql/")
+			endpoints, err := parseGraphQLEndpoints(cfGraphQLEndpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setConfig(t, &graphqlEndpoints, endpoints)
+			setConfig(t, &nextGraphQLEndpoint, tt.counter)
+
+			succeeded := 0
+			end := time.Now()
+			for i := 0; i < fetches; i++ {
+				resp, err := fetchStreamingTotals(context.Background(), testAccount(), end.Add(-cfgLookback), end)
+				if err == nil && totalMinutes(resp.Viewer.Accounts[0].AccountStreamMinutesViewedAdaptiveGroupsSum) == 240 {
+					succeeded++
+				}
+			}
+
+			if succeeded != tt.succeeded {
+				t.Errorf("got %d fetches succeeding, want %d", succeeded, tt.succeeded)
+			}
+			for _, e := range []struct {
+				m    *mockCloudflare
+				want int
+			}{{first, tt.firstCalls}, {second, tt.secondCalls}} {
+				got := len(e.m.requests("/graphql/"))
+				if got != e.want {
+					t.Errorf("got %d requests to %s, want %d", got, strings.TrimPrefix(e.m.URL, "http://"), e.want)
+				}
+			}
+		})
+	}
+}
+
+// TestRESTCancelled runs every rest call with a cancelled context. The rest
+// client panics on a failed request without a response, each call must fail
+// cleanly instead.
+func TestRESTCancelled(t *testing.T) {
+	uid := "ea95132c15732412d22c1476fa83f27a"
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"accounts", func(ctx context.Context) error {
+			_, err := fetchAccounts(ctx, testAccount().token)
+			return err
+		}},
+		{"account details", func(ctx context.Context) error {
+			_, err := fetchAccountDetails(ctx, testAccount().token, testAccount().ID)
+			return err
+		}},
+		{"explicit accounts", func(ctx context.Context) error {
+			if a := explicitAccounts(ctx, []string{testAccount().ID}); len(a) != 1 || a[0].Name != unknownAccountName(testAccount().ID) {
+				return fmt.Errorf("got accounts %v, want the unknown account name", a)
+			}
+			return errors.New("unresolved")
+		}},
+		{"video name", func(ctx context.Context) error {
+			if name := videoNames.name(ctx, testAccount(), uid); len(name) > 0 {
+				return fmt.Errorf("got video name %q", name)
+			}
+			return errors.New("unresolved")
+		}},
+		{"zone count", func(ctx context.Context) error {
+			fetchZoneCount(ctx, testAccount())
+			if n := countSeries(t, tenants.all(), "cloudflare_stream_account_info"); n != 0 {
+				return fmt.Errorf("got %d account info series", n)
+			}
+			return errors.New("not exported")
+		}},
+		{"rest fallback", func(ctx context.Context) error {
+			end := time.Now()
+			_, err := fetchStreamingTotalsREST(ctx, testAccount(), end.Add(-cfgLookback), end)
+			return err
+		}},
+		{"token permissions", func(ctx context.Context) error {
+			checkTokenPermissions(ctx)
+			return errors.New("logged")
+		}},
+		{"preregister", func(ctx context.Context) error {
+			preregisterMetrics(ctx)
+			if n := countSeries(t, gatherer, "cloudflare_streaming_minutes_viewed"); n != 0 {
+				return fmt.Errorf("got %d preregistered series", n)
+			}
+			return errors.New("not preregistered")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgFetchZoneCounts, true)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			setConfig(t, &accountNames, &accountNameCache{accounts: map[string]monitoredAccount{}})
+			setConfig(t, &videoNames, &videoNameCache{names: map[string]string{}})
+			setConfig(t, &placeholders.accounts, map[string]bool{})
+			resetMetrics(t)
+			m := newMockCloudflare(t,
+				restFixture(http.MethodGet, "/accounts", "accounts.json"),
+				restFixture(http.MethodGet, "/accounts/"+testAccount().ID, "account_details.json"),
+				restFixture(http.MethodGet, "/accounts/"+testAccount().ID+"/stream/"+uid, "stream_video.json"),
+				restFixture(http.MethodGet, "/accounts/"+testAccount().ID+"/stream/analytics/views", "rest_stream_views.json"),
+				restFixture(http.MethodGet, "/zones", "zones.json"),
+				restFixture(http.MethodGet, "/user/tokens/verify", "token_verify.json"),
+			)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			start := time.Now()
+			if err := tt.call(ctx); err == nil {
+				t.Error("got no error with a cancelled context")
+			} else if strings.HasPrefix(err.Error(), "got ") {
+				t.Error(err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s, want the cancelled request not retried", elapsed)
+			}
+			m.mu.Lock()
+			got := len(m.received)
+			m.mu.Unlock()
+			if got != 0 {
+				t.Errorf("got %d requests sent with a cancelled context", got)
+			}
+		})
+	}
+}
+
+func TestRESTRateLimitPastDeadline(t *testing.T) {
+	resetMetrics(t)
+	m := newMockCloudflare(t, restFixture(http.MethodGet, "/accounts", "accounts.json"))
+	setConfig(t, &cfgCfRateLimit, 0.2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := fetchAccounts(ctx, testAccount().token); err != nil {
+		t.Fatal(err)
+	}
+	// The next request may only be sent in 5s, past the deadline.
+	start := time.Now()
+	if _, err := fetchAccounts(ctx, testAccount().token); err == nil || !strings.Contains(err.Error(), "cf_rate_limit") {
+		t.Errorf("got error %v, want the rate limit wait to fail", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("failed after %s, want right away", elapsed)
+	}
+	if got := len(m.requests("/client/v4/accounts")); got != 1 {
+		t.Errorf("got %d account requests, want 1", got)
+	}
+}
+
+func TestRESTErrorResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		cancel bool
+		status int
+	}{
+		{"transport error", errors.New("connection refused"), false, http.StatusBadGateway},
+		{"deadline", fmt.Errorf("waiting: %w", context.DeadlineExceeded), false, http.StatusRequestTimeout},
+		{"cancelled request", errors.New("read: connection reset"), true, http.StatusRequestTimeout},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			cancel()
+		}
+		req := httptest.NewRequest(http.MethodGet, "https://api.cloudflare.com/client/v4/zones", nil).WithContext(ctx)
+		resp := restErrorResponse(req, tt.err)
+		cancel()
+
+		if resp.StatusCode != tt.status || resp.Request != req {
+			t.Errorf("%s: got status %d, want %d for the request", tt.name, resp.StatusCode, tt.status)
+		}
+		var body struct {
+			Errors []struct{ Message string } `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || len(body.Errors) != 1 || body.Errors[0].Message != tt.err.Error() {
+			t.Errorf("%s: got body %+v (%v), want the error message", tt.name, body, err)
+		}
+	}
+}
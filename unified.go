@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// With -unified_metrics every dataset is exported on the single
+// cloudflare_stream_usage gauge, told apart by its dataset, metric and unit
+// labels. One metric is easier to explore and aggregate across datasets, but
+// each series has to be filtered on those labels and the metric help and type can
+// only describe them all at once, so distinct metric names stay the default.
+const unifiedMetricName = "cloudflare_stream_usage"
+
+// unifiedMetricHelp is shared by the vecs of the unified metric, which live
+// in the registries of their datasets and are merged on gather.
+const unifiedMetricHelp = "Usage of the account by dataset, metric and unit"
+
+// Values of the metric label of the unified metric. Unique viewers have no
+// colo label, unlike the minutes viewed of the same dataset.
+const (
+	usageMetricViewed  = "viewed"
+	usageMetricUniques = "unique_viewers"
+)
+
+// usageLabels adds the dataset, metric and unit labels of the unified metric.
+func usageLabels(labels prometheus.Labels, dataset, metric, unit string) prometheus.Labels {
+	labels["dataset"] = dataset
+	labels["metric"] = metric
+	labels["unit"] = unit
+	return labels
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestUnifiedMetrics(t *testing.T) {
+	type series struct{ metric, unit string }
+	tests := []struct {
+		name    string
+		unit    string
+		uniques bool
+		want    map[series]float64
+	}{
+		{"minutes", "minutes", false, map[series]float64{{usageMetricViewed, "minutes"}: 80}},
+		{"seconds", "seconds", false, map[series]float64{{usageMetricViewed, "seconds"}: 4800}},
+		{"with unique viewers", "minutes", true, map[series]float64{{usageMetricViewed, "minutes"}: 80, {usageMetricUniques, "viewers"}: 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgUnifiedMetrics, true)
+			setConfig(t, &cfgViewedUnit, tt.unit)
+			setConfig(t, &cfgUniqueViewers, tt.uniques)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			newMockCloudflare(t,
+				graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"),
+				graphqlFixture("StreamUniqueViewers", "unique_viewers.json"),
+			)
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			g := tenants.all()
+			if got := countSeries(t, g, "cloudflare_stream_usage"); got != len(tt.want) {
+				t.Errorf("got %d usage series, want %d", got, len(tt.want))
+			}
+			for s, want := range tt.want {
+				labels := usageLabels(viewedLabels(testAccount(), ""), "streamMinutesViewedAdaptiveGroups", s.metric, s.unit)
+				if got, ok := gatheredValue(t, g, "cloudflare_stream_usage", labels); !ok || got != want {
+					t.Errorf("got usage %v (exported %t) for %v, want %v", got, ok, s, want)
+				}
+			}
+			for _, name := range []string{"cloudflare_streaming_minutes_viewed", "cloudflare_stream_seconds_viewed", "cloudflare_stream_unique_viewers"} {
+				if got := countSeries(t, g, name); got != 0 {
+					t.Errorf("got %d series of %s, want the usage gauge only", got, name)
+				}
+			}
+		})
+	}
+}
+
+// TestUnifiedUniquesByColo checks the unique viewers, which have no colo, do
+// not land among the per colo minutes viewed of the same dataset.
+func TestUnifiedUniquesByColo(t *testing.T) {
+	setConfig(t, &cfgUnifiedMetrics, true)
+	setConfig(t, &cfgUniqueViewers, true)
+	setConfig(t, &cfgGroupByColo, true)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &lastAccounts, &accountSet{})
+	lastAccounts.set([]monitoredAccount{testAccount()})
+	resetExportedColos(t)
+	resetMetrics(t)
+	newMockCloudflare(t,
+		graphqlFixture("StreamMinutesViewed", "streaming_analytics_one_colo.json"),
+		graphqlFixture("StreamUniqueViewers", "unique_viewers.json"),
+	)
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	const dataset = "streamMinutesViewedAdaptiveGroups"
+	tests := []struct {
+		name     string
+		g        prometheus.Gatherer
+		labels   prometheus.Labels
+		want     float64
+		wantSeen bool
+	}{
+		{"minutes viewed of the colo", tenants.dataset("viewed"), usageLabels(viewedLabels(testAccount(), "AMS"), dataset, usageMetricViewed, "minutes"), 60, true},
+		{"unique viewers without colo", tenants.dataset("uniques"), usageLabels(accountLabels(testAccount()), dataset, usageMetricUniques, "viewers"), 42, true},
+		{"no empty colo", tenants.all(), usageLabels(viewedLabels(testAccount(), ""), dataset, usageMetricUniques, "viewers"), 0, false},
+		{"not in the viewed registry", tenants.dataset("viewed"), usageLabels(accountLabels(testAccount()), dataset, usageMetricUniques, "viewers"), 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := gatheredValue(t, tt.g, "cloudflare_stream_usage", tt.labels)
+		if ok != tt.wantSeen || got != tt.want {
+			t.Errorf("%s: got %v (exported %t), want %v (exported %t)", tt.name, got, ok, tt.want, tt.wantSeen)
+		}
+	}
+
+	// The tenant path merges both registries into one family.
+	rec := httptest.NewRecorder()
+	tenantHandler("/metrics/", nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/"+testAccount().ID, nil))
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), "# TYPE cloudflare_stream_usage gauge") != 1 {
+		t.Errorf("got status %d and body\n%s\nwant a single usage family", rec.Code, rec.Body)
+	}
+}
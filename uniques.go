@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/machinebox/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Registered by registerAccountMetrics when -unique_viewers is set.
+var cfUniqueViewers *accountGaugeVec
+
+func registerUniqueViewersMetric() {
+	if cfgUnifiedMetrics {
+		cfUniqueViewers = newAccountGaugeVec("uniques", prometheus.GaugeOpts{
+			Name: unifiedMetricName,
+			Help: unifiedMetricHelp,
+		}, append(accountLabelNames(), "dataset", "metric", "unit"),
+		)
+		return
+	}
+	cfUniqueViewers = newAccountGaugeVec("uniques", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_unique_viewers",
+		Help: "Unique viewers of the account over the query window",
+	}, accountLabelNames(),
+	)
+}
+
+type cfResponseUniqueViewers struct {
+	Viewer struct {
+		Accounts []struct {
+			Groups []struct {
+				Uniq struct {
+					Uniques jsonUint64 `json:"uniques"`
+				} `json:"uniq"`
+			} `json:"streamMinutesViewedAdaptiveGroups"`
+		} `json:"accounts"`
+	} `json:"viewer"`
+}
+
+// buildUniqueViewersQuery queries -stream_dataset like buildStreamingQuery.
+func buildUniqueViewersQuery() string {
+	return fmt.Sprintf(`
+	query %s($accountID: String!, $mintime: Time!, $maxtime: Time!) {
+		viewer {
+			accounts(filter: {accountTag: $accountID} ) {
+				streamMinutesViewedAdaptiveGroups: %s(limit: 1, filter: { datetime_geq: $mintime, datetime_lt: $maxtime}) {
+					uniq {
+						uniques
+					}
+				}
+			}
+		}
+	}
+`, operationName("StreamUniqueViewers"), cfgStreamDataset)
+}
+
+// fetchUniqueViewers exports the unique viewers over the whole window.
+// Uniques of separate buckets cannot be added up, so the query groups by no
+// dimension and cloudflare aggregates the window in a single row.
+func fetchUniqueViewers(ctx context.Context, account monitoredAccount, start, end time.Time) {
+	ctx, span := tracer.Start(ctx, "fetchUniqueViewers", trace.WithAttributes(attribute.String("account.id", account.ID)))
+	defer span.End()
+
+	request := graphql.NewRequest(buildUniqueViewersQuery())
+	request.Header.Set("Authorization", "Bearer "+account.token.value)
+	request.Var("accountID", account.ID)
+	request.Var("mintime", start)
+	request.Var("maxtime", end)
+
+	graphqlClient := graphql.NewClient(cfGraphQLEndpoint, graphql.WithHTTPClient(apiClient))
+	var resp cfResponseUniqueViewers
+	if err := graphqlClient.Run(ctx, request, &resp); err != nil {
+		log.Errorf("Fetching unique viewers for %s: %s", account.Name, err)
+		return
+	}
+
+	// No row means nobody watched anything in the window.
+	var uniques uint64
+	for _, a := range resp.Viewer.Accounts {
+		for _, g := range a.Groups {
+			uniques = uint64(g.Uniq.Uniques)
+		}
+	}
+	labels := accountLabels(account)
+	if cfgUnifiedMetrics {
+		labels = usageLabels(labels, cfgStreamDataset, usageMetricUniques, "viewers")
+	}
+	cfUniqueViewers.set(account, labels, float64(uniques))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUniqueViewers(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture mockFixture
+		want    float64
+	}{
+		{"window uniques", graphqlFixture("StreamUniqueViewers", "unique_viewers.json"), 42},
+		{"no views", mockFixture{method: http.MethodPost, path: "/graphql/", body: `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": []}]}}}`}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgUniqueViewers, true)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			m := newMockCloudflare(t, tt.fixture)
+
+			end := time.Now()
+			fetchUniqueViewers(context.Background(), testAccount(), end.Add(-cfgLookback), end)
+
+			requests := m.requests("/graphql/")
+			if len(requests) != 1 {
+				t.Fatalf("got %d queries, want 1", len(requests))
+			}
+			// A single row without dimensions, aggregated by cloudflare over
+			// the window rather than summed over buckets here.
+			if q := requests[0].query; !strings.Contains(q, "limit: 1") || strings.Contains(q, "dimensions") {
+				t.Errorf("got query %s, want a single window-level row", q)
+			}
+			if got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_unique_viewers", accountLabels(testAccount())); !ok || got != tt.want {
+				t.Errorf("got unique viewers %v (exported %t), want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
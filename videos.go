@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/machinebox/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Registered by registerAccountMetrics when -top_videos is set.
+var cfVideoMinutesViewed *accountGaugeVec
+
+func registerVideoMetric() {
+	cfVideoMinutesViewed = newAccountGaugeVec("videos", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_video_minutes_viewed",
+		Help: "Minutes viewed over the query window of the most watched videos of the account",
+	}, append(accountLabelNames(), "video_id", "video_name"),
+	)
+}
+
+type cfResponseVideoMinutes struct {
+	Viewer struct {
+		Accounts []struct {
+			Groups []struct {
+				Sum struct {
+					MinutesViewed jsonUint64 `json:"minutesViewed"`
+				} `json:"sum"`
+				Dimensions struct {
+					UID string `json:"uid"`
+				} `json:"dimensions"`
+			} `json:"streamMinutesViewedAdaptiveGroups"`
+		} `json:"accounts"`
+	} `json:"viewer"`
+}
+
+// videoNameCache keeps the names of the videos resolved so far, failed
+// lookups are retried on the next scrape.
+type videoNameCache struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+var videoNames = &videoNameCache{names: map[string]string{}}
+
+// name returns the name of the video from its meta, or an empty string when
+// it has none or cannot be looked up.
+func (c *videoNameCache) name(ctx context.Context, account monitoredAccount, uid string) string {
+	c.mu.Lock()
+	name, ok := c.names[uid]
+	c.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	api, err := newAPIClient(account.token)
+	if err != nil {
+		return ""
+	}
+	video, err := api.StreamGetVideo(ctx, cloudflare.StreamParameters{AccountID: account.ID, VideoID: uid})
+	if err != nil {
+		log.Debugf("Resolving name of video %s: %s", uid, err)
+		return ""
+	}
+	name, _ = video.Meta["name"].(string)
+
+	c.mu.Lock()
+	c.names[uid] = name
+	c.mu.Unlock()
+	return name
+}
+
+// buildTopVideosQuery queries -stream_dataset and -stream_field like
+// buildStreamingQuery, aliased so the response decodes the same way.
+func buildTopVideosQuery() string {
+	return fmt.Sprintf(`
+	query %s($accountID: String!, $mintime: Time!, $maxtime: Time!, $limit: Int!) {
+		viewer {
+			accounts(filter: {accountTag: $accountID} ) {
+				streamMinutesViewedAdaptiveGroups: %s(limit: $limit, orderBy: [sum_%s_DESC], filter: { datetime_geq: $mintime, datetime_lt: $maxtime}) {
+					sum {
+						minutesViewed: %s
+					}
+
+					dimensions {
+						uid
+					}
+				}
+			}
+		}
+	}
+`, operationName("StreamTopVideos"), cfgStreamDataset, cfgStreamField, cfgStreamField)
+}
+
+// fetchTopVideos exports the minutes viewed of the -top_videos most watched
+// videos of the account. The series of the previous cycle are dropped first
+// so videos leaving the top do not linger.
+func fetchTopVideos(ctx context.Context, account monitoredAccount, start, end time.Time) {
+	ctx, span := tracer.Start(ctx, "fetchTopVideos", trace.WithAttributes(attribute.String("account.id", account.ID)))
+	defer span.End()
+
+	request := graphql.NewRequest(buildTopVideosQuery())
+	request.Header.Set("Authorization", "Bearer "+account.token.value)
+	request.Var("accountID", account.ID)
+	request.Var("mintime", start)
+	request.Var("maxtime", end)
+	request.Var("limit", cfgTopVideos)
+
+	graphqlClient := graphql.NewClient(cfGraphQLEndpoint, graphql.WithHTTPClient(apiClient))
+	var resp cfResponseVideoMinutes
+	if err := graphqlClient.Run(ctx, request, &resp); err != nil {
+		log.Errorf("Fetching top videos for %s: %s", account.Name, err)
+		return
+	}
+
+	cfVideoMinutesViewed.deletePartialMatch(account, accountLabels(account))
+	for _, a := range resp.Viewer.Accounts {
+		for i, g := range a.Groups {
+			if i >= cfgTopVideos {
+				break
+			}
+			labels := accountLabels(account)
+			labels["video_id"] = g.Dimensions.UID
+			labels["video_name"] = videoNames.name(ctx, account, g.Dimensions.UID)
+			cfVideoMinutesViewed.set(account, labels, float64(g.Sum.MinutesViewed))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTopVideos(t *testing.T) {
+	setConfig(t, &cfgTopVideos, 2)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	setConfig(t, &videoNames, &videoNameCache{names: map[string]string{}})
+	resetMetrics(t)
+	// The mock ignores $limit and returns more videos than asked for.
+	m := newMockCloudflare(t,
+		graphqlFixture("StreamTopVideos", "top_videos.json"),
+		restFixture(http.MethodGet, "/accounts/"+testAccount().ID+"/stream/ea95132c15732412d22c1476fa83f27a", "stream_video.json"),
+	)
+
+	end := time.Now()
+	fetchTopVideos(context.Background(), testAccount(), end.Add(-cfgLookback), end)
+
+	requests := m.requests("/graphql/")
+	if len(requests) != 1 || requests[0].variables["limit"] != 2.0 {
+		t.Fatalf("got queries %v, want one with limit 2", requests)
+	}
+	if got := countSeries(t, tenants.all(), "cloudflare_stream_video_minutes_viewed"); got != 2 {
+		t.Errorf("got %d video series, want the top 2", got)
+	}
+	tests := []struct {
+		id, name string
+		want     float64
+	}{
+		{"ea95132c15732412d22c1476fa83f27a", "Keynote 2022", 400},
+		// The lookup of this one gets a 404, it is exported without a name.
+		{"0e1b3ddd4e8c4e9aab9e154ba5145511", "", 300},
+	}
+	for _, tt := range tests {
+		labels := accountLabels(testAccount())
+		labels["video_id"], labels["video_name"] = tt.id, tt.name
+		if got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_video_minutes_viewed", labels); !ok || got != tt.want {
+			t.Errorf("got %v (exported %t) for video %s, want %v", got, ok, tt.id, tt.want)
+		}
+	}
+
+	// A video leaving the top is dropped on the next fetch.
+	m.mu.Lock()
+	m.fixtures = []mockFixture{{method: http.MethodPost, path: "/graphql/", body: `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": [{"sum": {"minutesViewed": 50}, "dimensions": {"uid": "5d5bc37ffcf54c9b82e996823bffbb81"}}]}]}}}`}}
+	m.mu.Unlock()
+	fetchTopVideos(context.Background(), testAccount(), end.Add(-cfgLookback), end)
+	if got := countSeries(t, tenants.all(), "cloudflare_stream_video_minutes_viewed"); got != 1 {
+		t.Errorf("got %d video series after the top changed, want 1", got)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registered by registerAccountMetrics once the account labels are known.
+var (
+	cfMinutesViewedWindow *accountGaugeVec
+	cfMinutesViewedLatest *accountGaugeVec
+)
+
+func registerWindowedMetrics() {
+	cfMinutesViewedWindow = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_minutes_viewed_window",
+		Help: "Minutes viewed summed over the -lookback window",
+	}, accountLabelNames(),
+	)
+	cfMinutesViewedLatest = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_minutes_viewed_latest",
+		Help: "Minutes viewed in the most recent complete bucket of the window",
+	}, accountLabelNames(),
+	)
+}
+
+// setWindowedMinutes exports the window total and the latest complete bucket
+// of the account, 0 when the window has no complete bucket.
+func setWindowedMinutes(account monitoredAccount, rows []cfStreamMinutesViewedGroup, end time.Time) {
+	labels := accountLabels(account)
+	cfMinutesViewedWindow.set(account, labels, float64(totalMinutes(rows)))
+
+	_, latest, _ := latestCompleteBucket(rows, end)
+	cfMinutesViewedLatest.set(account, labels, float64(latest))
+}
+
+func deleteWindowedMinutes(account monitoredAccount) {
+	cfMinutesViewedWindow.delete(account, accountLabels(account))
+	cfMinutesViewedLatest.delete(account, accountLabels(account))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWindowedMinutes(t *testing.T) {
+	var resp struct {
+		Data cfResponseStreamingAnalytics `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(readTestdata(t, "streaming_analytics.json")), &resp); err != nil {
+		t.Fatal(err)
+	}
+	rows := resp.Data.Viewer.Accounts[0].AccountStreamMinutesViewedAdaptiveGroupsSum
+
+	at := func(clock string) time.Time {
+		ts, err := time.Parse(time.RFC3339, "2022-09-01T"+clock+":00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	// Buckets of 120, 90 and 30 minutes at 10:00, 10:05 and 10:10.
+	tests := []struct {
+		end                    string
+		wantWindow, wantLatest float64
+	}{
+		{"10:13", 240, 90},
+		{"10:15", 240, 30},
+		{"10:20", 240, 30},
+		{"10:04", 240, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.end, func(t *testing.T) {
+			setConfig(t, &cfgGranularity, 5*time.Minute)
+			resetMetrics(t)
+
+			setWindowedMinutes(testAccount(), rows, at(tt.end))
+
+			for name, want := range map[string]float64{
+				"cloudflare_stream_minutes_viewed_window": tt.wantWindow,
+				"cloudflare_stream_minutes_viewed_latest": tt.wantLatest,
+			} {
+				if got, ok := gatheredValue(t, tenants.all(), name, accountLabels(testAccount())); !ok || got != want {
+					t.Errorf("got %s %v (exported %t), want %v", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWindowedMinutesFetched(t *testing.T) {
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	// Every bucket of the fixture is long complete.
+	for name, want := range map[string]float64{
+		"cloudflare_stream_minutes_viewed_window": 240,
+		"cloudflare_stream_minutes_viewed_latest": 30,
+		"cloudflare_streaming_minutes_viewed":     80,
+	} {
+		if got, ok := gatheredValue(t, tenants.all(), name, accountLabels(testAccount())); !ok || got != want {
+			t.Errorf("got %s %v (exported %t), want %v", name, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// granularityDimensions maps the supported -granularity values to the
+// datetime dimension of the adaptive groups dataset.
+var granularityDimensions = map[time.Duration]string{
+	time.Minute:      "datetimeMinute",
+	5 * time.Minute:  "datetimeFiveMinutes",
+	15 * time.Minute: "datetimeFifteenMinutes",
+	time.Hour:        "datetimeHour",
+}
+
+// maxGraphQLLimit is the most rows cloudflare returns for one adaptive groups
+// query.
+const maxGraphQLLimit = 10000
+
+// Registered by registerAccountMetrics once the account labels are known.
+var cfBucketsReturned *accountGaugeVec
+
+func registerBucketsMetric() {
+	cfBucketsReturned = newAccountGaugeVec("viewed", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_buckets_returned",
+		Help: "Distinct buckets in the last graphql response of the account, 0 means no data and close to -graphql_limit means truncation",
+	}, accountLabelNames(),
+	)
+}
+
+var (
+	cfQueryWindowSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_query_window_seconds",
+		Help: "Configured length of the window queried on each scrape, -lookback",
+	})
+
+	cfQueryGranularitySeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_stream_query_granularity_seconds",
+		Help: "Configured size of the buckets the query window is split in, -granularity",
+	})
+)
+
+func validateQueryWindow(lookback, granularity time.Duration) error {
+	if _, ok := granularityDimensions[granularity]; !ok {
+		return fmt.Errorf("unsupported -granularity %s, expected 1m, 5m, 15m or 1h", granularity)
+	}
+	if lookback < granularity {
+		return fmt.Errorf("-lookback %s must be at least the -granularity %s", lookback, granularity)
+	}
+	if lookback > maxQueryWindow {
+		return fmt.Errorf("-lookback %s exceeds the %s cloudflare retains", lookback, maxQueryWindow)
+	}
+	return nil
+}
+
+// sinceTime is the explicit start of the query window set by -since, zero
+// when the window trails now by -lookback.
+var sinceTime time.Time
+
+// parseSince parses -since as RFC3339 and checks it lies within the
+// retention cloudflare serves.
+func parseSince(raw string, now time.Time) (time.Time, error) {
+	since, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -since %q, expected RFC3339: %w", raw, err)
+	}
+	if !since.Before(now) {
+		return time.Time{}, fmt.Errorf("-since %s is not in the past", raw)
+	}
+	if now.Sub(since) > maxQueryWindow {
+		return time.Time{}, fmt.Errorf("-since %s is further back than the %s cloudflare retains", raw, maxQueryWindow)
+	}
+	return since, nil
+}
+
+// scrapeWindow is the window queried by a scrape, from -since when set.
+func scrapeWindow() (time.Time, time.Time) {
+	start, end := queryWindow(cfgLookback)
+	if !sinceTime.IsZero() {
+		start = sinceTime
+	}
+	return start, end
+}
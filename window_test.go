@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2022, 9, 30, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		raw     string
+		want    time.Time
+		wantErr bool
+	}{
+		{raw: "2022-09-01T10:00:00Z", want: time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)},
+		{raw: "2022-09-30T11:00:00+02:00", want: time.Date(2022, 9, 30, 9, 0, 0, 0, time.UTC)},
+		{raw: "2022-09-01", wantErr: true},
+		{raw: "yesterday", wantErr: true},
+		{raw: "2022-09-30T10:00:00Z", wantErr: true},
+		{raw: "2022-10-01T00:00:00Z", wantErr: true},
+		// Beyond the 30 days cloudflare retains.
+		{raw: "2022-08-31T09:59:59Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.raw, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSince(%q) = %s, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSince(%q): %s", tt.raw, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSinceMintime(t *testing.T) {
+	since := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+	setConfig(t, &sinceTime, since)
+	setConfig(t, &apiTokens, []apiToken{testAccount().token})
+	resetMetrics(t)
+	m := newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+	fetchStreamingAnalytics(context.Background(), testAccount())
+
+	requests := m.requests("/graphql/")
+	if len(requests) != 1 {
+		t.Fatalf("got %d graphql requests, want 1", len(requests))
+	}
+	mintime, err := time.Parse(time.RFC3339Nano, requests[0].variables["mintime"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mintime.Equal(since) {
+		t.Errorf("queried from %s, want -since %s", mintime, since)
+	}
+	maxtime, _ := time.Parse(time.RFC3339Nano, requests[0].variables["maxtime"].(string))
+	if time.Since(maxtime) > time.Hour {
+		t.Errorf("queried up to %s, want the window to still end near now", maxtime)
+	}
+}
+
+func TestClockSkewOffset(t *testing.T) {
+	for _, offset := range []time.Duration{0, 2 * time.Minute, 10 * time.Minute} {
+		t.Run(offset.String(), func(t *testing.T) {
+			setConfig(t, &cfgClockSkewOffset, offset)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			m := newMockCloudflare(t, graphqlFixture("StreamMinutesViewed", "streaming_analytics.json"))
+
+			before := time.Now()
+			fetchStreamingAnalytics(context.Background(), testAccount())
+			after := time.Now()
+
+			requests := m.requests("/graphql/")
+			if len(requests) != 1 {
+				t.Fatalf("got %d graphql requests, want 1", len(requests))
+			}
+			maxtime, err := time.Parse(time.RFC3339Nano, requests[0].variables["maxtime"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if maxtime.Before(before.Add(-offset)) || maxtime.After(after.Add(-offset)) {
+				t.Errorf("offset %s: got maxtime %s, want between %s and %s", offset, maxtime, before.Add(-offset), after.Add(-offset))
+			}
+			mintime, err := time.Parse(time.RFC3339Nano, requests[0].variables["mintime"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := maxtime.Sub(mintime); got != cfgLookback {
+				t.Errorf("offset %s: got a %s window, want -lookback %s", offset, got, cfgLookback)
+			}
+		})
+	}
+}
+
+func TestQueryWindowMetrics(t *testing.T) {
+	tests := []struct {
+		lookback, granularity       time.Duration
+		wantWindow, wantGranularity float64
+	}{
+		{30 * time.Minute, 5 * time.Minute, 1800, 300},
+		{time.Hour, time.Minute, 3600, 60},
+		{24 * time.Hour, time.Hour, 86400, 3600},
+	}
+	for _, tt := range tests {
+		setConfig(t, &cfgLookback, tt.lookback)
+		setConfig(t, &cfgGranularity, tt.granularity)
+		exportStartupMetrics()
+		if got := testutil.ToFloat64(cfQueryWindowSeconds); got != tt.wantWindow {
+			t.Errorf("-lookback %s: got cloudflare_stream_query_window_seconds %v, want %v", tt.lookback, got, tt.wantWindow)
+		}
+		if got := testutil.ToFloat64(cfQueryGranularitySeconds); got != tt.wantGranularity {
+			t.Errorf("-granularity %s: got cloudflare_stream_query_granularity_seconds %v, want %v", tt.granularity, got, tt.wantGranularity)
+		}
+	}
+}
+
+func TestBucketsReturned(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		body   string
+		byColo bool
+		want   float64
+	}{
+		{name: "buckets", file: "streaming_analytics.json", want: 3},
+		// 5 rows over 2 buckets, one per colo and bucket.
+		{name: "rows per colo", file: "streaming_analytics_colos.json", byColo: true, want: 2},
+		{name: "no data", body: `{"data": {"viewer": {"accounts": [{"streamMinutesViewedAdaptiveGroups": []}]}}}`, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgGroupByColo, tt.byColo)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			fixture := graphqlFixture("StreamMinutesViewed", tt.file)
+			fixture.body = tt.body
+			newMockCloudflare(t, fixture)
+
+			fetchStreamingAnalytics(context.Background(), testAccount())
+
+			if got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_buckets_returned", accountLabels(testAccount())); !ok || got != tt.want {
+				t.Errorf("got buckets returned %v (exported %t), want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "sync"
+
+// workerPool runs jobs on at most size goroutines at once. It is shared by the
+// per-account scrape, the batched scrape and the per-account datasets.
+type workerPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+// run blocks until a worker is free and starts job on it. A panicking job is
+// logged and counted instead of taking the exporter down.
+func (p *workerPool) run(job func()) {
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.slots }()
+		defer recoverScrapePanic()
+
+		job()
+	}()
+}
+
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	for _, size := range []int{1, 3} {
+		pool := newWorkerPool(size)
+
+		var mu sync.Mutex
+		running, most, done := 0, 0, 0
+		for i := 0; i < 10; i++ {
+			pool.run(func() {
+				mu.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				done++
+				mu.Unlock()
+			})
+		}
+		pool.wait()
+
+		if most != size || done != 10 {
+			t.Errorf("pool of %d ran %d jobs with at most %d at once, want 10 jobs with %d at once", size, done, most, size)
+		}
+	}
+}
+
+func TestWorkerPoolPanic(t *testing.T) {
+	pool := newWorkerPool(1)
+	pool.run(func() { panic("boom") })
+	ran := false
+	pool.run(func() { ran = true })
+	pool.wait()
+
+	if !ran {
+		t.Error("a panicking job took the worker down")
+	}
+}
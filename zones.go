@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Registered by registerAccountMetrics when -fetch_zone_counts is set.
+var cfAccountInfo *accountGaugeVec
+
+func registerAccountInfoMetric() {
+	cfAccountInfo = newAccountGaugeVec("", prometheus.GaugeOpts{
+		Name: "cloudflare_stream_account_info",
+		Help: "Always 1, the zones label holds the number of zones of the account",
+	}, append(accountLabelNames(), "zones"),
+	)
+}
+
+// fetchZoneCount lists the zones of the account through the rest api and
+// replaces its info series. When listing fails the previous one is kept.
+func fetchZoneCount(ctx context.Context, account monitoredAccount) {
+	ctx, span := tracer.Start(ctx, "fetchZoneCount", trace.WithAttributes(attribute.String("account.id", account.ID)))
+	defer span.End()
+
+	api, err := newAPIClient(account.token)
+	if err != nil {
+		log.Errorf("Fetching zones for %s: %s", account.Name, err)
+		return
+	}
+	zones, err := api.ListZonesContext(ctx, cloudflare.WithZoneFilters("", account.ID, ""))
+	if err != nil {
+		log.Errorf("Fetching zones for %s: %s", account.Name, err)
+		return
+	}
+
+	labels := accountLabels(account)
+	cfAccountInfo.deletePartialMatch(account, labels)
+	labels["zones"] = strconv.Itoa(len(zones.Result))
+	cfAccountInfo.set(account, labels, 1)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestZoneCounts(t *testing.T) {
+	noZones := `{"success": true, "errors": [], "messages": [], "result": [], "result_info": {"page": 1, "per_page": 50, "total_pages": 0, "count": 0, "total_count": 0}}`
+	tests := []struct {
+		name    string
+		fixture mockFixture
+		want    string
+	}{
+		{"zones", restFixture(http.MethodGet, "/zones", "zones.json"), "3"},
+		{"no zones", mockFixture{method: http.MethodGet, path: "/client/v4/zones", body: noZones}, "0"},
+		// The series of an earlier scrape is kept when listing fails.
+		{"listing fails", mockFixture{method: http.MethodGet, path: "/client/v4/zones", status: http.StatusForbidden, file: "rest_error.json"}, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &cfgFetchZoneCounts, true)
+			setConfig(t, &apiTokens, []apiToken{testAccount().token})
+			resetMetrics(t)
+			earlier := mockFixture{method: http.MethodGet, path: "/client/v4/zones", body: `{"success": true, "errors": [], "messages": [], "result": [{"id": "1", "name": "example.com"}], "result_info": {"page": 1, "per_page": 50, "total_pages": 1, "count": 1, "total_count": 1}}`}
+			m := newMockCloudflare(t, earlier, tt.fixture)
+
+			fetchZoneCount(context.Background(), testAccount())
+			fetchZoneCount(context.Background(), testAccount())
+
+			requests := m.requests("/client/v4/zones")
+			if len(requests) != 2 || requests[1].params.Get("account.id") != testAccount().ID {
+				t.Fatalf("got zone requests %v, want two filtered on the account", requests)
+			}
+			if got := countSeries(t, tenants.all(), "cloudflare_stream_account_info"); got != 1 {
+				t.Errorf("got %d info series, want 1", got)
+			}
+			labels := accountLabels(testAccount())
+			labels["zones"] = tt.want
+			if got, ok := gatheredValue(t, tenants.all(), "cloudflare_stream_account_info", labels); !ok || got != 1 {
+				t.Errorf("got info %v (exported %t) with %s zones, want 1", got, ok, tt.want)
+			}
+		})
+	}
+}